@@ -0,0 +1,103 @@
+package autotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetain is the number of runs kept for each package when RunOptions.Retain isn't set
+const DefaultRetain = 5
+
+// RunArtifacts contains the files saved for a single test run
+type RunArtifacts struct {
+	RunID   string
+	Dir     string
+	Profile string // coverage profile written by go test
+	Log     string // raw go test -json output
+	Stderr  string
+	Timing  string
+}
+
+// RunTiming is saved with the artifacts of each run
+type RunTiming struct {
+	RunID    string
+	Folder   string
+	Args     []string
+	Start    time.Time
+	End      time.Time
+	Elapsed  float64 // seconds
+	ExitCode int
+}
+
+var runCounter int64
+
+// newRunArtifacts creates a unique artifact folder for a run of a package and removes the oldest runs of the
+// package so that only retain runs are kept
+func newRunArtifacts(baseDir, folder string, retain int) (*RunArtifacts, error) {
+	if retain <= 0 {
+		retain = DefaultRetain
+	}
+	packageDir := filepath.Join(baseDir, getPackageKey(folder))
+	runID := fmt.Sprintf("%s-%06d", time.Now().Format("20060102-150405.000"), atomic.AddInt64(&runCounter, 1))
+	dir := filepath.Join(packageDir, runID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	pruneRuns(packageDir, retain)
+	return &RunArtifacts{
+		RunID:   runID,
+		Dir:     dir,
+		Profile: filepath.Join(dir, "cover.out"),
+		Log:     filepath.Join(dir, "test.json"),
+		Stderr:  filepath.Join(dir, "stderr.txt"),
+		Timing:  filepath.Join(dir, "timing.json"),
+	}, nil
+}
+
+func (a *RunArtifacts) save(stdout, stderr []byte, timing RunTiming) error {
+	timing.RunID = a.RunID
+	timing.Elapsed = timing.End.Sub(timing.Start).Seconds()
+	timingJSON, err := json.MarshalIndent(timing, "", "  ")
+	if err != nil {
+		return err
+	}
+	for filename, content := range map[string][]byte{a.Log: stdout, a.Stderr: stderr, a.Timing: timingJSON} {
+		if err := os.WriteFile(filename, content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPackageKey converts a folder into a name which can be used as a single folder
+func getPackageKey(folder string) string {
+	folder = filepath.Clean(folder)
+	if folder == "." {
+		return "root"
+	}
+	return strings.NewReplacer("/", "_", `\`, "_", ":", "_", "..", "up").Replace(folder)
+}
+
+// pruneRuns removes the oldest run folders. Run IDs start with a timestamp so they sort oldest first
+func pruneRuns(packageDir string, retain int) {
+	entries, err := os.ReadDir(packageDir)
+	if err != nil {
+		return
+	}
+	runs := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			runs = append(runs, entry.Name())
+		}
+	}
+	sort.Strings(runs)
+	for i := 0; i < len(runs)-retain; i++ {
+		os.RemoveAll(filepath.Join(packageDir, runs[i]))
+	}
+}
@@ -0,0 +1,58 @@
+package autotest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRunArtifacts(t *testing.T) {
+	baseDir := t.TempDir()
+	runIDs := []string{}
+	for i := 0; i < 4; i++ {
+		a, err := newRunArtifacts(baseDir, "api/store", 2)
+		require.NoError(t, err)
+		runIDs = append(runIDs, a.RunID)
+	}
+	entries, err := os.ReadDir(filepath.Join(baseDir, "api_store"))
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, runIDs[2:], names, "only the newest runs are retained")
+
+	other, err := newRunArtifacts(baseDir, "api", 0)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(baseDir, "api", other.RunID), other.Dir)
+}
+
+func TestRunArtifactsSave(t *testing.T) {
+	a, err := newRunArtifacts(t.TempDir(), ".", 1)
+	require.NoError(t, err)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, a.save([]byte("stdout"), []byte("stderr"), RunTiming{Folder: ".", Start: start, End: start.Add(1500 * time.Millisecond), ExitCode: 1}))
+
+	log, _ := os.ReadFile(a.Log)
+	assert.Equal(t, "stdout", string(log))
+	stderr, _ := os.ReadFile(a.Stderr)
+	assert.Equal(t, "stderr", string(stderr))
+	var timing RunTiming
+	content, _ := os.ReadFile(a.Timing)
+	require.NoError(t, json.Unmarshal(content, &timing))
+	assert.Equal(t, a.RunID, timing.RunID)
+	assert.Equal(t, 1.5, timing.Elapsed)
+	assert.Equal(t, 1, timing.ExitCode)
+}
+
+func TestGetPackageKey(t *testing.T) {
+	assert.Equal(t, "root", getPackageKey("."))
+	assert.Equal(t, "root", getPackageKey("./"))
+	assert.Equal(t, "api_v2_store", getPackageKey("api/v2/store"))
+	assert.Equal(t, "up_other", getPackageKey("../other"))
+}
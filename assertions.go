@@ -0,0 +1,157 @@
+package autotest
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/logrusorgru/aurora"
+)
+
+// outputSegment is either a plain line of test output, an assertion with expected and actual values or a diff
+// printed by the test itself, like the output of cmp.Diff
+type outputSegment struct {
+	Text          string
+	Assertion     *assertion
+	Diff          []diffItem
+	ExpectedFirst bool // removed diff lines are the expected values
+}
+
+type assertion struct {
+	Expected string
+	Actual   string
+}
+
+var testifyActual = regexp.MustCompile(`^actual\s*:\s?(.*)$`)
+var diffHeader = regexp.MustCompile(`\(-(\w+) \+(\w+)\)`)
+var deepEqualGot = regexp.MustCompile(`^(.*?),\s*want\w*:?$`)
+var gotWant = regexp.MustCompile(`\bgot(?:\s+\w+\s*[:=]|\s*[:=]|)\s*(.+?),?\s+want(?:ed)?(?:\s+\w+\s*[:=]|\s*[:=]|)\s*(.+)$`)
+var wantGot = regexp.MustCompile(`\b(?:want|wanted|expected)(?:\s+\w+\s*[:=]|\s*[:=]|)\s*(.+?),?\s+(?:but\s+)?got(?:\s+\w+\s*[:=]|\s*[:=]|)\s*(.+)$`)
+var testifyFields = []string{"Test:", "Messages:", "Error Trace:", "Error:"}
+
+// parseAssertions finds the expected and actual values of failed assertions in test output. It recognizes
+// testify's expected/actual blocks, diffs with a (-want +got) header, got/want pairs and reflect.DeepEqual
+// style dumps of the form "Func() = \n<got>, want \n<want>"
+func parseAssertions(output string) []outputSegment {
+	lines := strings.Split(output, "\n")
+	segments := []outputSegment{}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "expected:") && i+1 < len(lines) && testifyActual.MatchString(lines[i+1]):
+			actual := testifyActual.FindStringSubmatch(lines[i+1])[1]
+			segments = append(segments, outputSegment{Assertion: &assertion{Expected: strings.TrimSpace(line[len("expected:"):]), Actual: actual}})
+			i++
+		case line == "Diff:": // testify's own diff is replaced by ours
+			for i+1 < len(lines) && !hasAnyPrefix(lines[i+1], testifyFields) {
+				i++
+			}
+		case diffHeader.MatchString(line):
+			segments = append(segments, outputSegment{Text: line})
+			header := diffHeader.FindStringSubmatch(line)
+			segment := outputSegment{ExpectedFirst: isExpectedName(header[1])}
+			for i+1 < len(lines) && !logPrefix.MatchString(lines[i+1]) && !hasAnyPrefix(lines[i+1], testifyFields) {
+				i++
+				segment.Diff = append(segment.Diff, getDiffItem(lines[i]))
+			}
+			segments = append(segments, segment)
+		case strings.HasSuffix(line, "=") && i+2 < len(lines) && deepEqualGot.MatchString(lines[i+1]):
+			got := deepEqualGot.FindStringSubmatch(lines[i+1])[1]
+			segments = append(segments, outputSegment{Text: line}, outputSegment{Assertion: &assertion{Expected: lines[i+2], Actual: got}})
+			i += 2
+		default:
+			segments = append(segments, getOneLineAssertion(line)...)
+		}
+	}
+	return segments
+}
+
+func getOneLineAssertion(line string) []outputSegment {
+	var prefix string
+	var a *assertion
+	if match := gotWant.FindStringSubmatchIndex(line); match != nil {
+		prefix, a = line[:match[0]], &assertion{Actual: line[match[2]:match[3]], Expected: line[match[4]:match[5]]}
+	} else if match := wantGot.FindStringSubmatchIndex(line); match != nil {
+		prefix, a = line[:match[0]], &assertion{Expected: line[match[2]:match[3]], Actual: line[match[4]:match[5]]}
+	} else {
+		return []outputSegment{{Text: line}}
+	}
+	segments := []outputSegment{}
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		segments = append(segments, outputSegment{Text: prefix})
+	}
+	return append(segments, outputSegment{Assertion: a})
+}
+
+func isExpectedName(name string) bool {
+	return strings.HasPrefix(name, "want") || strings.HasPrefix(name, "exp")
+}
+
+func getDiffItem(line string) diffItem {
+	if len(line) > 0 && (line[0] == diffRemoved || line[0] == diffAdded) {
+		return diffItem{line[0], line[1:]}
+	}
+	return diffItem{diffEqual, line}
+}
+
+func hasAssertions(segments []outputSegment) bool {
+	for _, segment := range segments {
+		if segment.Assertion != nil || segment.Diff != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renderSegments colors expected values green and actual values red. Short single line values are shown one
+// above the other with the differing words highlighted. Multi-line values are shown as a unified diff
+func renderSegments(segments []outputSegment) []string {
+	lines := []string{}
+	for _, segment := range segments {
+		switch {
+		case segment.Assertion != nil && (strings.Contains(segment.Assertion.Expected, "\n") || strings.Contains(segment.Assertion.Actual, "\n")):
+			lines = append(lines, aurora.Green("--- expected").String(), aurora.Red("+++ actual").String())
+			diff := getDiff(strings.Split(segment.Assertion.Expected, "\n"), strings.Split(segment.Assertion.Actual, "\n"))
+			lines = append(lines, renderDiff(diff, true)...)
+		case segment.Assertion != nil:
+			lines = append(lines, renderWordDiff(segment.Assertion)...)
+		case segment.Diff != nil:
+			lines = append(lines, renderDiff(segment.Diff, segment.ExpectedFirst)...)
+		default:
+			lines = append(lines, aurora.Gray(10, segment.Text).String())
+		}
+	}
+	return lines
+}
+
+func renderDiff(diff []diffItem, expectedFirst bool) []string {
+	lines := []string{}
+	for _, item := range diff {
+		text := string(item.Kind) + " " + item.Text
+		if item.Kind == diffEqual {
+			lines = append(lines, aurora.Gray(10, text).String())
+		} else if (item.Kind == diffRemoved) == expectedFirst {
+			lines = append(lines, aurora.Green(text).String())
+		} else {
+			lines = append(lines, aurora.Red(text).String())
+		}
+	}
+	return lines
+}
+
+func renderWordDiff(a *assertion) []string {
+	var expected, actual strings.Builder
+	expected.WriteString(aurora.Gray(15, "expected: ").String())
+	actual.WriteString(aurora.Gray(15, "actual:   ").String())
+	for _, item := range getDiff(splitWords(a.Expected), splitWords(a.Actual)) {
+		switch item.Kind {
+		case diffEqual:
+			expected.WriteString(aurora.Green(item.Text).String())
+			actual.WriteString(aurora.Red(item.Text).String())
+		case diffRemoved:
+			expected.WriteString(aurora.Bold(aurora.BrightGreen(item.Text)).String())
+		case diffAdded:
+			actual.WriteString(aurora.Bold(aurora.BrightRed(item.Text)).String())
+		}
+	}
+	return []string{expected.String(), actual.String()}
+}
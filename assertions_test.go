@@ -0,0 +1,62 @@
+package autotest
+
+import (
+	"testing"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAssertions(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []outputSegment
+	}{
+		{"Plain", "some output\nmore output", []outputSegment{{Text: "some output"}, {Text: "more output"}}},
+		{"Testify",
+			"tracker_test.go:40:\nError Trace:\ttracker_test.go:40\nError:      \tNot equal:\nexpected: 1\nactual  : 0\n\nDiff:\n+++ Actual\n@@ -1 +1 @@\n-1\n+0\nTest:       \tTestGetCoverageDiff",
+			[]outputSegment{{Text: "tracker_test.go:40:"}, {Text: "Error Trace:\ttracker_test.go:40"}, {Text: "Error:      \tNot equal:"},
+				{Assertion: &assertion{Expected: "1", Actual: "0"}}, {Text: ""}, {Text: "Test:       \tTestGetCoverageDiff"}}},
+		{"cmp.Diff",
+			"api_test.go:12: mismatch (-want +got):\nstruct{\n-  A: 1,\n+  A: 2,\n}\napi_test.go:13: done",
+			[]outputSegment{{Text: "api_test.go:12: mismatch (-want +got):"},
+				{ExpectedFirst: true, Diff: []diffItem{{' ', "struct{"}, {'-', "  A: 1,"}, {'+', "  A: 2,"}, {' ', "}"}}},
+				{Text: "api_test.go:13: done"}}},
+		{"Reversed cmp.Diff", "diff (-got +want):\n-a", []outputSegment{{Text: "diff (-got +want):"}, {Diff: []diffItem{{'-', "a"}}}}},
+		{"Got want", "runner_test.go:10: Sum() got 3, want 4", []outputSegment{{Text: "runner_test.go:10: Sum()"}, {Assertion: &assertion{Expected: "4", Actual: "3"}}}},
+		{"Named got want", "parseCoverageLine() got filename = a.go, want b.go", []outputSegment{{Text: "parseCoverageLine()"}, {Assertion: &assertion{Expected: "b.go", Actual: "a.go"}}}},
+		{"Expected got", "expected 5 but got 6", []outputSegment{{Assertion: &assertion{Expected: "5", Actual: "6"}}}},
+		{"DeepEqual dump",
+			"runner_test.go:89: parseTestEventLine() =\n&{a b}, wantTest\n&{a c}",
+			[]outputSegment{{Text: "runner_test.go:89: parseTestEventLine() ="}, {Assertion: &assertion{Expected: "&{a c}", Actual: "&{a b}"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseAssertions(tt.output))
+		})
+	}
+}
+
+func TestRenderSegments(t *testing.T) {
+	lines := renderSegments([]outputSegment{{Text: "text"}, {Assertion: &assertion{Expected: "a b", Actual: "a c"}}})
+	assert.Equal(t, []string{
+		aurora.Gray(10, "text").String(),
+		aurora.Gray(15, "expected: ").String() + aurora.Green("a").String() + aurora.Green(" ").String() + aurora.Bold(aurora.BrightGreen("b")).String(),
+		aurora.Gray(15, "actual:   ").String() + aurora.Red("a").String() + aurora.Red(" ").String() + aurora.Bold(aurora.BrightRed("c")).String(),
+	}, lines)
+
+	lines = renderSegments([]outputSegment{{Assertion: &assertion{Expected: "a\nb", Actual: "a\nc"}}})
+	assert.Equal(t, []string{
+		aurora.Green("--- expected").String(), aurora.Red("+++ actual").String(),
+		aurora.Gray(10, "  a").String(), aurora.Green("- b").String(), aurora.Red("+ c").String(),
+	}, lines)
+
+	lines = renderSegments([]outputSegment{{Diff: []diffItem{{'-', "got"}, {'+', "want"}}}})
+	assert.Equal(t, []string{aurora.Red("- got").String(), aurora.Green("+ want").String()}, lines)
+}
+
+func TestPrintOutputAssertion(t *testing.T) {
+	assert.Equal(t, aurora.Gray(10, "\noutput:plain\n").String(), printOutput("plain"))
+	assert.Equal(t, aurora.Gray(10, "\noutput:").String()+renderWordDiff(&assertion{Expected: "4", Actual: "3"})[0]+"\n"+renderWordDiff(&assertion{Expected: "4", Actual: "3"})[1]+"\n", printOutput("got 3, want 4"))
+}
@@ -0,0 +1,114 @@
+package autotest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitBaseline runs tests on a git ref checked out in a temporary worktree. The results are used as the baseline
+// that later runs are compared with instead of the first run after startup
+type GitBaseline struct {
+	RepoRoot string
+	Commit   string
+	Dir      string // folder of the worktree
+}
+
+// mainBranches are tried in order when finding the merge base for an empty ref
+var mainBranches = []string{"main", "master", "origin/main", "origin/master"}
+
+// NewGitBaseline checks out ref of the repository containing dir in a worktree within tempDir. An empty ref uses
+// the merge base of HEAD and main. Worktrees left behind by earlier runs which didn't close are pruned first
+func NewGitBaseline(dir, ref, tempDir string) (*GitBaseline, error) {
+	root, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runGit(root, "worktree", "prune"); err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		if ref, err = getMergeBase(root); err != nil {
+			return nil, err
+		}
+	}
+	commit, err := runGit(root, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("unknown baseline ref %s: %w", ref, err)
+	}
+	worktree, err := filepath.Abs(filepath.Join(tempDir, "baseline-"+shortCommit(commit)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runGit(root, "worktree", "add", "--detach", worktree, commit); err != nil {
+		return nil, err
+	}
+	return &GitBaseline{RepoRoot: filepath.Clean(root), Commit: commit, Dir: worktree}, nil
+}
+
+func getMergeBase(root string) (string, error) {
+	for _, branch := range mainBranches {
+		if base, err := runGit(root, "merge-base", "HEAD", branch); err == nil {
+			return base, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find the merge base with any of %s", strings.Join(mainBranches, ", "))
+}
+
+// Close removes the worktree
+func (b *GitBaseline) Close() error {
+	_, err := runGit(b.RepoRoot, "worktree", "remove", "--force", b.Dir)
+	return err
+}
+
+// Folder returns the location of a folder within the worktree and whether it exists at the baseline commit
+func (b *GitBaseline) Folder(folder string) (string, bool) {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(b.RepoRoot, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	baselineFolder := filepath.Join(b.Dir, rel)
+	stat, err := os.Stat(baselineFolder)
+	return baselineFolder, err == nil && stat.IsDir()
+}
+
+// Run runs the tests of a folder at the baseline commit. The result has the folder name of the working tree so
+// it can be tracked as that folder's baseline. Nil is returned when the folder didn't exist at the baseline
+func (b *GitBaseline) Run(folder string, options RunOptions) *TestResult {
+	baselineFolder, ok := b.Folder(folder)
+	if !ok {
+		return nil
+	}
+	if options.Module != nil {
+		moduleDir, _ := b.Folder(options.Module.Dir)
+		options.Module = &Module{Path: options.Module.Path, Dir: moduleDir}
+	}
+	options.TempDir = filepath.Join(options.TempDir, "baseline")
+	options.RunRegex, options.Focus, options.PatchBase, options.CoverPkg, options.ChangedTests = "", false, "", false, false
+	result := RunTests(baselineFolder, options)
+	result.Folder = folder
+	return result
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.SetDir(dir)
+	out, exitCode := cmd.SimpleOutput()
+	if exitCode != 0 {
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// shortCommit abbreviates a commit hash to 12 characters
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,97 @@
+package autotest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gitRepo(t *testing.T) string {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"go.mod": "module example.com/repo\n", "pkg/pkg.go": "package pkg\n"})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"symbolic-ref", "HEAD", "refs/heads/main"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "first"},
+		{"checkout", "-q", "-b", "feature"},
+	} {
+		_, err := runGit(root, args...)
+		require.NoError(t, err)
+	}
+	return root
+}
+
+func TestGitBaseline(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewOSCreator()
+	root := gitRepo(t)
+	writeFiles(t, root, map[string]string{"newpkg/new.go": "package newpkg\n"})
+
+	b, err := NewGitBaseline(filepath.Join(root, "pkg"), "", t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, 40, len(b.Commit))
+	assert.FileExists(t, filepath.Join(b.Dir, "pkg", "pkg.go"))
+
+	folder, ok := b.Folder(filepath.Join(root, "pkg"))
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(b.Dir, "pkg"), folder)
+	_, ok = b.Folder(filepath.Join(root, "newpkg"))
+	assert.False(t, ok, "folder didn't exist at the baseline commit")
+	_, ok = b.Folder(os.TempDir())
+	assert.False(t, ok)
+	assert.Nil(t, b.Run(filepath.Join(root, "newpkg"), RunOptions{}))
+
+	require.NoError(t, b.Close())
+	assert.NoDirExists(t, b.Dir)
+
+	_, err = NewGitBaseline(root, "unknown-ref", t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestGitBaselinePrune(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewOSCreator()
+	root := gitRepo(t)
+	b, err := NewGitBaseline(root, "main", t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(b.Dir)) // as if autotest crashed and its temporary folder was removed
+
+	b, err = NewGitBaseline(root, "main", filepath.Dir(b.Dir))
+	require.NoError(t, err, "the stale worktree is pruned")
+	require.NoError(t, b.Close())
+	out, err := runGit(root, "worktree", "list", "--porcelain")
+	require.NoError(t, err)
+	assert.NotContains(t, out, b.Dir)
+}
+
+func TestGitBaselineRun(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	b := &GitBaseline{RepoRoot: filepath.Join(t.TempDir(), "repo"), Dir: t.TempDir()}
+	require.NoError(t, os.Mkdir(filepath.Join(b.Dir, "pkg"), 0755))
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	module := &Module{Path: "example.com/repo", Dir: b.RepoRoot}
+	result := b.Run(filepath.Join(b.RepoRoot, "pkg"), RunOptions{TempDir: t.TempDir(), Module: module, RunRegex: "TestA", Focus: true})
+	require.NotNil(t, result)
+	assert.Equal(t, filepath.Join(b.RepoRoot, "pkg"), result.Folder)
+	assert.Equal(t, RunModeFull, result.Mode)
+}
+
+func TestSetBaseline(t *testing.T) {
+	defer resetTracking()
+	first := &TestResult{Folder: "setBaseline"}
+	SetBaseline(first)
+	assert.True(t, HasBaseline("setBaseline"))
+	assert.NotNil(t, Track(&TestResult{Folder: "setBaseline"}), "first run is compared with the baseline")
+
+	baseline := &TestResult{Folder: "setBaseline"}
+	SetBaseline(baseline)
+	assert.Equal(t, baseline, getFolderResults("setBaseline").Original)
+}
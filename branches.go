@@ -0,0 +1,209 @@
+package autotest
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// Branch is one way through an if statement, a switch or a select
+type Branch struct {
+	Line      int
+	Condition string // e.g. "if a > 0", "else of if a > 0", "switch a: case 1, 2" or "select: default"
+	Taken     bool
+}
+
+// BranchesTaken returns the number of branches of the function which ran
+func (c FunctionCoverage) BranchesTaken() int {
+	taken := 0
+	for _, branch := range c.Branches {
+		if branch.Taken {
+			taken++
+		}
+	}
+	return taken
+}
+
+// addBranchCoverage sets the branches of each function whose file is in the profile. Coverage profiles only record
+// blocks of statements, so each branch is mapped to the block its body starts in. The operands of && and || don't
+// start blocks of their own, so whether each one was evaluated can't be told and they aren't reported
+func addBranchCoverage(coverage []FunctionCoverage, profile *Profile, sources *sourceFiles) {
+	blocksByFile := make(map[string][]ProfileBlock)
+	for _, block := range profile.Blocks {
+		blocksByFile[block.File] = append(blocksByFile[block.File], block)
+	}
+	for i, item := range coverage {
+		filename, ok := profile.Filenames[item.Path]
+		if !ok {
+			continue
+		}
+		file := sources.parse(filename)
+		if file == nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && fn.Name.Name == item.Function && sources.fset.Position(fn.Pos()).Line == item.LineNumber {
+				b := &branchFinder{fset: sources.fset, blocks: blocksByFile[item.Path], counts: profile.hasCounts()}
+				coverage[i].Branches = b.find(fn)
+				break
+			}
+		}
+	}
+}
+
+type branchFinder struct {
+	fset     *token.FileSet
+	blocks   []ProfileBlock
+	counts   bool // blocks record how often they ran rather than just whether they ran
+	branches []Branch
+}
+
+// find returns the branches of a function, or nil when it has none. Explicit branches are taken when the block
+// their body starts in ran. The implicit else of an if without one and a switch without a default are only
+// reported when it can be told whether they were taken: in count mode, when the statement ran more often than its
+// branches. In set mode, when the body of the if always leaves it and the statement after the if ran
+func (b *branchFinder) find(fn *ast.FuncDecl) []Branch {
+	next := make(map[ast.Stmt]ast.Stmt) // the statement following each statement in its list
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		var list []ast.Stmt
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			list = n.List
+		case *ast.CaseClause:
+			list = n.Body
+		case *ast.CommClause:
+			list = n.Body
+		}
+		for i := 0; i < len(list)-1; i++ {
+			next[list[i]] = list[i+1]
+		}
+		return true
+	})
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt:
+			b.addIf(n, next[n])
+		case *ast.SwitchStmt:
+			header := "switch"
+			if n.Tag != nil {
+				header += " " + printNode(n.Tag)
+			}
+			b.addCases(n, header, n.Body.List)
+		case *ast.TypeSwitchStmt:
+			b.addCases(n, "switch "+printNode(n.Assign), n.Body.List)
+		case *ast.SelectStmt:
+			b.addCases(n, "select", n.Body.List)
+		}
+		return true
+	})
+	return b.branches
+}
+
+func (b *branchFinder) addIf(n *ast.IfStmt, next ast.Stmt) {
+	condition := "if " + printNode(n.Cond)
+	body, _ := b.count(bodyPos(n.Body.List, n.Body.Lbrace))
+	b.add(n.Pos(), condition, body > 0)
+	switch {
+	case n.Else != nil:
+		pos := n.Else.Pos()
+		if block, ok := n.Else.(*ast.BlockStmt); ok {
+			pos = bodyPos(block.List, block.Lbrace)
+		}
+		count, _ := b.count(pos)
+		b.add(n.Else.Pos(), "else of "+condition, count > 0)
+	case b.counts:
+		if total, ok := b.count(n.Pos()); ok {
+			b.add(n.Pos(), "else of "+condition, total > body)
+		}
+	case next != nil && isTerminating(n.Body.List):
+		if count, ok := b.count(next.Pos()); ok {
+			b.add(n.Pos(), "else of "+condition, count > 0)
+		}
+	}
+}
+
+func (b *branchFinder) addCases(n ast.Stmt, header string, clauses []ast.Stmt) {
+	hasDefault, sum := false, 0
+	for _, clause := range clauses {
+		var name string
+		var pos token.Pos
+		switch clause := clause.(type) {
+		case *ast.CaseClause:
+			name, pos, hasDefault = "default", bodyPos(clause.Body, clause.Colon), hasDefault || clause.List == nil
+			if clause.List != nil {
+				exprs := []string{}
+				for _, expr := range clause.List {
+					exprs = append(exprs, printNode(expr))
+				}
+				name = "case " + strings.Join(exprs, ", ")
+			}
+		case *ast.CommClause:
+			name, pos, hasDefault = "default", bodyPos(clause.Body, clause.Colon), hasDefault || clause.Comm == nil
+			if clause.Comm != nil {
+				name = "case " + printNode(clause.Comm)
+			}
+		}
+		count, _ := b.count(pos)
+		sum += count
+		b.add(clause.Pos(), header+": "+name, count > 0)
+	}
+	if _, ok := n.(*ast.SelectStmt); !ok && !hasDefault && b.counts {
+		if total, ok := b.count(n.Pos()); ok {
+			b.add(n.Pos(), header+": no case matched", total > sum)
+		}
+	}
+}
+
+func (b *branchFinder) add(pos token.Pos, condition string, taken bool) {
+	b.branches = append(b.branches, Branch{Line: b.fset.Position(pos).Line, Condition: condition, Taken: taken})
+}
+
+// count returns the count of the innermost block containing pos
+func (b *branchFinder) count(pos token.Pos) (int, bool) {
+	p := b.fset.Position(pos)
+	var found *ProfileBlock
+	for i, block := range b.blocks {
+		starts := block.StartLine < p.Line || block.StartLine == p.Line && block.StartCol <= p.Column
+		ends := block.EndLine > p.Line || block.EndLine == p.Line && block.EndCol >= p.Column
+		if !starts || !ends {
+			continue
+		}
+		inner := found == nil || block.StartLine > found.StartLine || block.StartLine == found.StartLine && block.StartCol > found.StartCol
+		if inner || block.StartLine == found.StartLine && block.StartCol == found.StartCol && block.Count > found.Count {
+			found = &b.blocks[i]
+		}
+	}
+	if found == nil {
+		return 0, false
+	}
+	return found.Count, true
+}
+
+// bodyPos returns where the coverage block of a body starts: at its first statement or, for an empty body, just
+// after its opening brace or colon
+func bodyPos(list []ast.Stmt, open token.Pos) token.Pos {
+	if len(list) != 0 {
+		return list[0].Pos()
+	}
+	return open + 1
+}
+
+// isTerminating returns true when the statements always leave the enclosing block
+func isTerminating(list []ast.Stmt) bool {
+	if len(list) == 0 {
+		return false
+	}
+	switch last := list[len(list)-1].(type) {
+	case *ast.ReturnStmt, *ast.BranchStmt:
+		return true
+	case *ast.ExprStmt:
+		call, ok := last.X.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Fun.(*ast.Ident)
+		return ok && ident.Name == "panic"
+	}
+	return false
+}
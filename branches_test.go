@@ -0,0 +1,132 @@
+package autotest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const branchSource = `package bc
+
+func F(a int, c chan int) int {
+	if a > 0 {
+		a++
+	} else if a < -5 {
+		a--
+	} else {
+		a = 0
+	}
+	if a == 3 {
+		return 1
+	}
+	switch a {
+	case 1:
+		a++
+	case 2:
+	default:
+		a--
+	}
+	select {
+	case <-c:
+	default:
+		a++
+	}
+	return a
+}
+
+func G() {}
+`
+
+// profile of go test -covermode count with F(1, nil) and F(2, nil)
+var branchBlocks = []ProfileBlock{
+	{File: "bc/a.go", StartLine: 4, StartCol: 2, EndLine: 4, EndCol: 11, NumStmt: 1, Count: 2},
+	{File: "bc/a.go", StartLine: 5, StartCol: 3, EndLine: 6, EndCol: 1, NumStmt: 1, Count: 2},
+	{File: "bc/a.go", StartLine: 6, StartCol: 9, EndLine: 6, EndCol: 19, NumStmt: 1, Count: 0},
+	{File: "bc/a.go", StartLine: 7, StartCol: 3, EndLine: 8, EndCol: 1, NumStmt: 1, Count: 0},
+	{File: "bc/a.go", StartLine: 9, StartCol: 3, EndLine: 10, EndCol: 1, NumStmt: 1, Count: 0},
+	{File: "bc/a.go", StartLine: 11, StartCol: 2, EndLine: 11, EndCol: 12, NumStmt: 1, Count: 2},
+	{File: "bc/a.go", StartLine: 12, StartCol: 3, EndLine: 13, EndCol: 1, NumStmt: 1, Count: 1},
+	{File: "bc/a.go", StartLine: 14, StartCol: 2, EndLine: 14, EndCol: 11, NumStmt: 1, Count: 1},
+	{File: "bc/a.go", StartLine: 16, StartCol: 3, EndLine: 16, EndCol: 6, NumStmt: 1, Count: 0},
+	{File: "bc/a.go", StartLine: 17, StartCol: 9, EndLine: 17, EndCol: 9, NumStmt: 0, Count: 1},
+	{File: "bc/a.go", StartLine: 19, StartCol: 3, EndLine: 19, EndCol: 6, NumStmt: 1, Count: 0},
+	{File: "bc/a.go", StartLine: 21, StartCol: 2, EndLine: 21, EndCol: 9, NumStmt: 1, Count: 1},
+	{File: "bc/a.go", StartLine: 22, StartCol: 11, EndLine: 22, EndCol: 11, NumStmt: 0, Count: 0},
+	{File: "bc/a.go", StartLine: 24, StartCol: 3, EndLine: 24, EndCol: 6, NumStmt: 1, Count: 1},
+	{File: "bc/a.go", StartLine: 26, StartCol: 2, EndLine: 26, EndCol: 10, NumStmt: 1, Count: 1},
+}
+
+func TestAddBranchCoverage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	require.NoError(t, os.WriteFile(filename, []byte(branchSource), 0644))
+	coverage := []FunctionCoverage{
+		{Path: "bc/a.go", Filename: "a.go", Function: "F", LineNumber: 3},
+		{Path: "bc/a.go", Filename: "a.go", Function: "G", LineNumber: 29},
+		{Filename: "total", Function: "(statements)"},
+	}
+	profile := &Profile{Mode: CoverModeCount, Blocks: branchBlocks, Filenames: map[string]string{"bc/a.go": filename}}
+	addBranchCoverage(coverage, profile, newSourceFiles())
+	assert.Equal(t, []Branch{
+		{Line: 4, Condition: "if a > 0", Taken: true},
+		{Line: 6, Condition: "else of if a > 0", Taken: false},
+		{Line: 6, Condition: "if a < -5", Taken: false},
+		{Line: 8, Condition: "else of if a < -5", Taken: false},
+		{Line: 11, Condition: "if a == 3", Taken: true},
+		{Line: 11, Condition: "else of if a == 3", Taken: true},
+		{Line: 15, Condition: "switch a: case 1", Taken: false},
+		{Line: 17, Condition: "switch a: case 2", Taken: true},
+		{Line: 18, Condition: "switch a: default", Taken: false},
+		{Line: 22, Condition: "select: case <-c", Taken: false},
+		{Line: 23, Condition: "select: default", Taken: true},
+	}, coverage[0].Branches)
+	assert.Equal(t, 5, coverage[0].BranchesTaken())
+	assert.Nil(t, coverage[1].Branches)
+	assert.Nil(t, coverage[2].Branches)
+
+	profile.Mode = CoverModeSet
+	addBranchCoverage(coverage, profile, newSourceFiles())
+	assert.Contains(t, coverage[0].Branches, Branch{Line: 11, Condition: "else of if a == 3", Taken: true}, "the if returns, so the switch ran without it")
+	assert.Len(t, coverage[0].Branches, 11)
+}
+
+func TestAddBranchCoverageImplicit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	require.NoError(t, os.WriteFile(filename, []byte(`package a
+
+func H(a int) int {
+	if a > 0 {
+		a++
+	}
+	switch a {
+	case 1:
+		a--
+	}
+	return a
+}
+`), 0644))
+	profile := &Profile{Mode: CoverModeCount, Filenames: map[string]string{"a/a.go": filename}, Blocks: []ProfileBlock{
+		{File: "a/a.go", StartLine: 4, StartCol: 2, EndLine: 4, EndCol: 11, NumStmt: 1, Count: 3},
+		{File: "a/a.go", StartLine: 5, StartCol: 3, EndLine: 6, EndCol: 1, NumStmt: 1, Count: 3},
+		{File: "a/a.go", StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 11, NumStmt: 1, Count: 3},
+		{File: "a/a.go", StartLine: 9, StartCol: 3, EndLine: 9, EndCol: 6, NumStmt: 1, Count: 1},
+		{File: "a/a.go", StartLine: 11, StartCol: 2, EndLine: 11, EndCol: 10, NumStmt: 1, Count: 3},
+	}}
+	coverage := []FunctionCoverage{{Path: "a/a.go", Filename: "a.go", Function: "H", LineNumber: 3}}
+	addBranchCoverage(coverage, profile, newSourceFiles())
+	assert.Equal(t, []Branch{
+		{Line: 4, Condition: "if a > 0", Taken: true},
+		{Line: 4, Condition: "else of if a > 0", Taken: false},
+		{Line: 8, Condition: "switch a: case 1", Taken: true},
+		{Line: 7, Condition: "switch a: no case matched", Taken: true},
+	}, coverage[0].Branches)
+
+	profile.Mode = CoverModeSet
+	addBranchCoverage(coverage, profile, newSourceFiles())
+	assert.Equal(t, []Branch{
+		{Line: 4, Condition: "if a > 0", Taken: true},
+		{Line: 8, Condition: "switch a: case 1", Taken: true},
+	}, coverage[0].Branches, "implicit branches are unknown in set mode unless the if body returns")
+}
@@ -0,0 +1,267 @@
+package autotest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ChangeResolver maps changed files to the watched package folders which need to be tested. It is not safe for
+// concurrent use. The embedded files of each package are listed in the background
+type ChangeResolver struct {
+	workspace *Workspace
+	root      string            // absolute path of the watched root folder
+	folders   map[string]string // absolute path to watched folder name
+
+	mutex      sync.Mutex
+	refresh    sync.WaitGroup
+	embeds     map[string][]string // absolute embedded file path to absolute package folders
+	directives map[string]string   // //go:embed lines of each listed go file, to tell when the list is out of date
+	refreshing bool
+	stale      bool // the embedded files changed while they were being listed
+}
+
+type listedPackage struct {
+	Dir                string
+	ImportPath         string
+	Standard           bool
+	Module             *struct{ Main bool } // Main is true for the modules being worked on
+	GoFiles            []string
+	CgoFiles           []string
+	TestGoFiles        []string
+	XTestGoFiles       []string
+	IgnoredGoFiles     []string
+	EmbedPatterns      []string
+	TestEmbedPatterns  []string
+	XTestEmbedPatterns []string
+	EmbedFiles         []string
+	TestEmbedFiles     []string
+	XTestEmbedFiles    []string
+}
+
+// NewChangeResolver creates a resolver for the watched folders of a workspace. Package folders created later are
+// only tested when they are within root and not in a folder ignored by the go command
+func NewChangeResolver(workspace *Workspace, root string, folders []string) *ChangeResolver {
+	r := &ChangeResolver{workspace: workspace, folders: make(map[string]string), embeds: make(map[string][]string),
+		directives: make(map[string]string)}
+	r.root, _ = filepath.Abs(root)
+	for _, folder := range folders {
+		if abs, err := filepath.Abs(folder); err == nil {
+			r.folders[abs] = folder
+		}
+	}
+	r.refreshEmbeds()
+	return r
+}
+
+// Resolve returns the watched folders affected by a changed file. Go files affect their own package, files in a
+// testdata folder affect the package containing it, embedded files affect the packages embedding them and
+// go.mod, go.sum and go.work affect every package in the module or workspace
+func (r *ChangeResolver) Resolve(file string) []string {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Dir(file)
+	switch name := filepath.Base(file); {
+	case name == "go.work":
+		return r.watched(r.allFolders())
+	case name == "go.mod" || name == "go.sum":
+		r.refreshEmbeds()
+		return r.watched(r.moduleFolders(r.workspace.ModuleFor(dir)))
+	case strings.HasSuffix(name, ".go") && !isInTestdata(dir):
+		if r.embedsChanged(file) {
+			r.refreshEmbeds()
+		}
+		r.addFolder(dir)
+		return r.watched([]string{dir})
+	}
+
+	dirs := r.embeddedBy(file)
+	if testdataParent, ok := getTestdataParent(dir); ok {
+		dirs = append(dirs, testdataParent)
+	}
+	return r.watched(dirs)
+}
+
+// addFolder starts tracking package folders created after the resolver
+func (r *ChangeResolver) addFolder(dir string) {
+	if _, ok := r.folders[dir]; ok || !r.isWatchable(dir) {
+		return
+	}
+	folder := dir
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, dir); err == nil {
+			folder = rel
+		}
+	}
+	r.folders[dir] = folder
+}
+
+// isWatchable returns true when dir is within the root folder and none of its folders are ignored
+func (r *ChangeResolver) isWatchable(dir string) bool {
+	rel, err := filepath.Rel(r.root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if rel != "." && isIgnoredFolder(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// watched converts absolute folders to watched folder names, removing duplicates and unwatched folders
+func (r *ChangeResolver) watched(dirs []string) []string {
+	folders := []string{}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if folder, ok := r.folders[dir]; ok && !seen[folder] {
+			seen[folder] = true
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+func (r *ChangeResolver) allFolders() []string {
+	dirs := []string{}
+	for dir := range r.folders {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func (r *ChangeResolver) moduleFolders(module *Module) []string {
+	dirs := []string{}
+	if module == nil {
+		return dirs
+	}
+	for dir := range r.folders {
+		if r.workspace.ModuleFor(dir) == module {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (r *ChangeResolver) embeddedBy(file string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.embeds[file]
+}
+
+// embedsChanged returns true when a go file was added or removed, or its //go:embed lines differ from when the
+// packages were listed. Other edits can't change the embedded files, so they don't need go list to run again
+func (r *ChangeResolver) embedsChanged(file string) bool {
+	directives, err := readEmbedDirectives(file)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	listed, ok := r.directives[file]
+	switch {
+	case err != nil:
+		return ok // removed
+	case ok && listed == directives:
+		return false
+	}
+	r.directives[file] = directives // saves before the list is refreshed are compared with this
+	return true
+}
+
+// readEmbedDirectives returns the //go:embed lines of a go file
+func readEmbedDirectives(filename string) (string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	directives := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "//go:embed") {
+			directives = append(directives, line)
+		}
+	}
+	return strings.Join(directives, "\n"), nil
+}
+
+// refreshEmbeds lists the embedded files of every package in the background. Changes resolved in the meantime use
+// the previous list
+func (r *ChangeResolver) refreshEmbeds() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.refreshing {
+		r.stale = true
+		return
+	}
+	r.refreshing = true
+	r.refresh.Add(1)
+	go r.listEmbeds()
+}
+
+func (r *ChangeResolver) listEmbeds() {
+	defer r.refresh.Done()
+	for {
+		embeds, directives := make(map[string][]string), make(map[string]string)
+		for _, module := range r.workspace.Modules {
+			for _, pkg := range listPackages(module.Dir, "./...") {
+				embedded := append(append(pkg.EmbedFiles, pkg.TestEmbedFiles...), pkg.XTestEmbedFiles...)
+				for _, name := range embedded {
+					embeddedPath := filepath.Join(pkg.Dir, filepath.FromSlash(name))
+					embeds[embeddedPath] = append(embeds[embeddedPath], pkg.Dir)
+				}
+				patterns := len(pkg.EmbedPatterns) + len(pkg.TestEmbedPatterns) + len(pkg.XTestEmbedPatterns)
+				for _, name := range append(append(append(pkg.GoFiles, pkg.CgoFiles...), pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+					directives[filepath.Join(pkg.Dir, name)] = ""
+					if patterns != 0 { // files of packages without patterns have no directives
+						directives[filepath.Join(pkg.Dir, name)], _ = readEmbedDirectives(filepath.Join(pkg.Dir, name))
+					}
+				}
+				for _, name := range pkg.IgnoredGoFiles { // their patterns aren't listed
+					directives[filepath.Join(pkg.Dir, name)], _ = readEmbedDirectives(filepath.Join(pkg.Dir, name))
+				}
+			}
+		}
+
+		r.mutex.Lock()
+		again := r.stale // list again when the embedded files changed while listing
+		r.embeds, r.directives, r.refreshing, r.stale = embeds, directives, again, false
+		r.mutex.Unlock()
+		if !again {
+			return
+		}
+	}
+}
+
+// listPackages runs go list from dir with the flags and patterns given in args. Packages with errors are still listed
+func listPackages(dir string, args ...string) []listedPackage {
+	out, _ := runGoTool(dir, append([]string{"list", "-e", "-json"}, args...))
+	packages := []listedPackage{}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		var pkg listedPackage
+		if err := decoder.Decode(&pkg); err != nil {
+			break
+		}
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+func isInTestdata(dir string) bool {
+	_, ok := getTestdataParent(dir)
+	return ok
+}
+
+// getTestdataParent returns the package folder containing the outermost testdata folder in dir
+func getTestdataParent(dir string) (string, bool) {
+	parts := strings.Split(dir, string(filepath.Separator))
+	for i, part := range parts {
+		if part == "testdata" && i > 0 {
+			return strings.Join(parts[:i], string(filepath.Separator)), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package autotest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeResolver(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":                    "module example.com/root\n",
+		"api/api.go":                "package api",
+		"api/testdata/golden.json":  "{}",
+		"api/testdata/deep/in.json": "{}",
+		"web/web.go":                "package web",
+		"web/static/index.html":     "<html>",
+		"tools/go.mod":              "module example.com/tools\n",
+		"tools/tools.go":            "package tools",
+	})
+	w, err := LoadWorkspace(root)
+	require.NoError(t, err)
+	folders := []string{filepath.Join(root, "api"), filepath.Join(root, "web"), filepath.Join(root, "tools"), root}
+	listOutput := fmt.Sprintf(`{"Dir": %q, "GoFiles": ["web.go"], "EmbedPatterns": ["static/index.html"], "EmbedFiles": ["static/index.html"]}
+{"Dir": %q, "GoFiles": ["api.go"]}`, filepath.Join(root, "web"), filepath.Join(root, "api"))
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{{SimpleOutputOut: []byte(listOutput)}, {}, {SimpleOutputOut: []byte(listOutput)}, {}})
+	r := NewChangeResolver(w, root, folders)
+	defer r.refresh.Wait() // before exec is restored
+	r.refresh.Wait()
+
+	assert.Equal(t, []string{filepath.Join(root, "api")}, r.Resolve(filepath.Join(root, "api", "api.go")))
+	r.refresh.Wait()
+	assert.Equal(t, []string{filepath.Join(root, "api")}, r.Resolve(filepath.Join(root, "api", "testdata", "golden.json")))
+	assert.Equal(t, []string{filepath.Join(root, "api")}, r.Resolve(filepath.Join(root, "api", "testdata", "deep", "in.json")))
+	assert.Equal(t, []string{filepath.Join(root, "web")}, r.Resolve(filepath.Join(root, "web", "static", "index.html")))
+	assert.Equal(t, []string{}, r.Resolve(filepath.Join(root, "README.md")))
+
+	moduleFolders := r.Resolve(filepath.Join(root, "go.sum"))
+	sort.Strings(moduleFolders)
+	assert.Equal(t, []string{root, filepath.Join(root, "api"), filepath.Join(root, "web")}, moduleFolders)
+	assert.Equal(t, []string{filepath.Join(root, "tools")}, r.Resolve(filepath.Join(root, "tools", "go.mod")))
+	assert.Equal(t, 4, len(r.Resolve(filepath.Join(root, "go.work"))))
+}
+
+func TestChangeResolverEmbedsChanged(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"api/api.go": "package api\n",
+		"web/web.go": "package web\n\nimport _ \"embed\"\n\n//go:embed static/index.html\nvar index string\n",
+	})
+	r := &ChangeResolver{directives: map[string]string{
+		filepath.Join(root, "api", "api.go"):  "",
+		filepath.Join(root, "web", "web.go"):  "//go:embed static/index.html",
+		filepath.Join(root, "api", "gone.go"): "",
+	}}
+	assert.False(t, r.embedsChanged(filepath.Join(root, "api", "api.go")), "edits without directives don't list the packages again")
+	assert.False(t, r.embedsChanged(filepath.Join(root, "web", "web.go")))
+
+	writeFiles(t, root, map[string]string{"web/web.go": "package web\n\nimport _ \"embed\"\n\n//go:embed static/*\nvar index string\n"})
+	assert.True(t, r.embedsChanged(filepath.Join(root, "web", "web.go")))
+	assert.False(t, r.embedsChanged(filepath.Join(root, "web", "web.go")), "compared with the last save")
+	writeFiles(t, root, map[string]string{"api/new.go": "package api\n"})
+	assert.True(t, r.embedsChanged(filepath.Join(root, "api", "new.go")), "added")
+	assert.True(t, r.embedsChanged(filepath.Join(root, "api", "gone.go")), "removed")
+}
+
+func TestChangeResolverNewFolder(t *testing.T) {
+	root := t.TempDir()
+	r := NewChangeResolver(&Workspace{}, root, []string{})
+	newFolder := filepath.Join(root, "newpkg")
+	folders := r.Resolve(filepath.Join(newFolder, "new.go"))
+	require.Equal(t, 1, len(folders))
+	abs, _ := filepath.Abs(folders[0])
+	assert.Equal(t, newFolder, abs)
+
+	assert.Equal(t, []string{}, r.Resolve(filepath.Join(root, "vendor", "pkg", "pkg.go")), "ignored folder")
+	assert.Equal(t, []string{}, r.Resolve(filepath.Join(root, "_build", "gen.go")), "underscore folder")
+	assert.Equal(t, []string{}, r.Resolve(filepath.Join(t.TempDir(), "outside.go")), "outside of the root")
+}
+
+func TestGetTestdataParent(t *testing.T) {
+	parent, ok := getTestdataParent(filepath.Join("a", "b", "testdata", "c", "testdata"))
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join("a", "b"), parent)
+	_, ok = getTestdataParent(filepath.Join("a", "b"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/robarchibald/autotest"
+)
+
+// command is a single key press. Arg contains the text entered for commands which prompt for input
+type command struct {
+	Key rune
+	Arg string
+}
+
+var prompts = map[rune]string{
+	'p': "package pattern (empty to clear): ",
+	't': "test name regex (empty to clear): ",
+}
+
+// readKeys reads commands from the terminal until stdin is closed. Nothing is read when stdin isn't a terminal
+func readKeys() (<-chan command, func()) {
+	keys := make(chan command)
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return keys, func() {}
+	}
+	restore, err := makeRaw()
+	if err != nil {
+		return keys, func() {}
+	}
+
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		ask := func(prompt string) string {
+			restore() // line editing and echo while typing the prompt. stdout may be the JSON report
+			fmt.Fprint(os.Stderr, prompt)
+			line, _ := r.ReadString('\n')
+			makeRaw()
+			return strings.TrimSpace(line)
+		}
+		for {
+			cmd, err := readCommand(r, keysNeedEnter, ask)
+			if err != nil {
+				return
+			}
+			keys <- cmd
+		}
+	}()
+	return keys, restore
+}
+
+// readCommand reads a key and, for keys which prompt, the text entered. When each key is followed by enter, the
+// rest of the key's line is read too. It is the text when given, e.g. "p api/...", otherwise the prompt is asked
+func readCommand(r *bufio.Reader, lineMode bool, ask func(prompt string) string) (command, error) {
+	key, _, err := r.ReadRune()
+	if err != nil {
+		return command{}, err
+	}
+	cmd := command{Key: key}
+	prompt, hasPrompt := prompts[key]
+	if lineMode && key != '\r' && key != '\n' {
+		rest, err := r.ReadString('\n')
+		if err != nil && rest == "" {
+			return command{}, err
+		}
+		if rest = strings.TrimSpace(rest); hasPrompt && rest != "" {
+			cmd.Arg = rest
+			return cmd, nil
+		}
+	}
+	if hasPrompt {
+		cmd.Arg = ask(prompt)
+	}
+	return cmd, nil
+}
+
+// handleCommand runs a key command and returns true when autotest should quit
+func (a *app) handleCommand(cmd command) bool {
+	switch cmd.Key {
+	case 'a':
+		a.queueAll()
+	case 'f':
+		a.queueFailing()
+	case 'p':
+		a.mutex.Lock()
+		a.packageFilter = cmd.Arg
+		a.mutex.Unlock()
+		for _, folder := range a.watchFolders {
+			if !matchesPackageFilter(folder, cmd.Arg) { // left out of the module coverage until tested again
+				autotest.ForgetFolder(folder)
+			}
+		}
+		a.queueAll()
+	case 't':
+		if _, err := regexp.Compile(cmd.Arg); err != nil {
+			a.reporter.Error(fmt.Errorf("invalid test name regex: %w", err))
+			return false
+		}
+		a.mutex.Lock()
+		a.testFilter = cmd.Arg
+		a.mutex.Unlock()
+		a.queueAll()
+	case 'c':
+		if a.console != nil {
+			a.reporter.Message("coverage section " + onOff(a.console.ToggleCoverage()))
+		}
+	case 'b':
+		autotest.ResetBaseline()
+		a.reporter.Message("baseline reset to the last results")
+	case 'q':
+		return true
+	case '\r', '\n':
+	default:
+		a.printHelp()
+	}
+	return false
+}
+
+func (a *app) printHelp() {
+	if a.console == nil { // keep the help out of the JSON report
+		return
+	}
+	a.mutex.RLock()
+	packageFilter, testFilter := a.packageFilter, a.testFilter
+	a.mutex.RUnlock()
+
+	var filters string
+	if packageFilter != "" {
+		filters += fmt.Sprintf(" package: %s", packageFilter)
+	}
+	if testFilter != "" {
+		filters += fmt.Sprintf(" test: %s", testFilter)
+	}
+	a.console.Message(fmt.Sprint(aurora.Gray(12, "a all · f failing · p package · t test · c coverage "+onOff(a.console.ShowCoverage)+" · b baseline · q quit"), aurora.Cyan(filters)))
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// matchesPackageFilter matches a folder against a glob pattern, or a substring when the filter has no glob characters
+func matchesPackageFilter(folder, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	folder = filepath.ToSlash(folder)
+	if strings.ContainsAny(filter, "*?[") {
+		match, _ := filepath.Match(filter, folder)
+		return match
+	}
+	return strings.Contains(folder, filter)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCommandLineMode(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("a\r\np\r\napi/...\r\nt TestA\r\nt\r\n\r\n"))
+	asked := []string{}
+	ask := func(prompt string) string {
+		asked = append(asked, prompt)
+		line, _ := r.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+	commands := []command{}
+	for {
+		cmd, err := readCommand(r, true, ask)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		commands = append(commands, cmd)
+	}
+	assert.Equal(t, []command{{Key: 'a'}, {Key: 'p', Arg: "api/..."}, {Key: 't', Arg: "TestA"}, {Key: 't'}}, commands,
+		"the enter after the key isn't read as the prompt's answer")
+	assert.Equal(t, []string{prompts['p'], prompts['t']}, asked)
+}
+
+func TestReadCommandRaw(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("fp api/...\n"))
+	ask := func(string) string {
+		line, _ := r.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+	cmd, err := readCommand(r, false, ask)
+	require.NoError(t, err)
+	assert.Equal(t, command{Key: 'f'}, cmd)
+	cmd, err = readCommand(r, false, ask)
+	require.NoError(t, err)
+	assert.Equal(t, command{Key: 'p', Arg: "api/..."}, cmd)
+}
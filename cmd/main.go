@@ -1,10 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -12,69 +16,388 @@ import (
 	"github.com/robarchibald/gobounce"
 )
 
+type app struct {
+	w            *gobounce.Filewatcher
+	scheduler    *autotest.Scheduler
+	workspace    *autotest.Workspace
+	resolver     *autotest.ChangeResolver
+	baseline     *autotest.GitBaseline
+	exporter     *autotest.CoverageExporter
+	index        *autotest.TestIndex
+	indexer      *autotest.Scheduler // indexes the coverage of each test in the background
+	integration  *autotest.IntegrationCoverage
+	integrator   *autotest.Scheduler // runs the integration command in the background
+	reporter     autotest.Reporter
+	console      *autotest.ConsoleReporter // nil when console output is turned off
+	selectTests  bool                      // run the tests covering changed lines before the rest of the package
+	watchFolders []string
+	runOptions   autotest.RunOptions // settings shared by every run
+	testsToTrack chan *autotest.TestResult
+	integrations chan *autotest.IntegrationResult
+
+	mutex         sync.RWMutex
+	packageFilter string
+	testFilter    string
+}
+
 func main() {
+	var absTolerance, relTolerance float64
+	concurrency := flag.Int("concurrency", defaultConcurrency(), "maximum number of packages to test at once")
+	focus := flag.Bool("focus", true, "after a failure, rerun only the failing tests until they pass")
+	artifactDir := flag.String("artifacts", "", "folder to keep the coverage profile, log and timing of each run in. Defaults to a temporary folder removed on exit")
+	retain := flag.Int("retain", autotest.DefaultRetain, "number of runs to keep for each package")
+	excludes := flag.String("exclude", "", "comma separated glob patterns of files to leave out of coverage, e.g. **/mocks/*.go,*_string.go")
+	includeGenerated := flag.Bool("include-generated", false, "include generated files in coverage")
+	coverMode := flag.String("covermode", "", "coverage mode: set, count or atomic. count and atomic record how often each line runs, for the hottest lines list and the HTML heatmap")
+	hottestLines := flag.Int("hottest-lines", autotest.DefaultHottestLines, "number of most run lines to list in count and atomic mode. 0 disables")
+	coverPkg := flag.Bool("coverpkg", false, "measure coverage of the whole module with -coverpkg so code tested by other packages' tests is reported as covered")
+	flag.Float64Var(&absTolerance, "cover-tolerance", 0, "ignore coverage changes of at most this many percentage points")
+	flag.Float64Var(&relTolerance, "cover-relative-tolerance", 0, "ignore coverage changes of at most this fraction of the original coverage")
+	flag.BoolVar(&autotest.CoverageDiffOptions.DecreasesOnly, "cover-decreases-only", false, "only report functions whose coverage went down")
+	flag.IntVar(&autotest.CoverageDiffOptions.OscillationLimit, "cover-oscillations", autotest.CoverageDiffOptions.OscillationLimit, "treat functions whose coverage goes up and down this many times as nondeterministic. 0 disables")
+	crapCutoff := flag.Float64("crap-cutoff", 0, "leave functions with a lower CRAP risk score out of the coverage table")
+	patchBase := flag.String("patch-base", "HEAD", "git ref to find the changed lines against for patch coverage. Empty disables patch coverage")
+	patchThreshold := flag.Float64("patch-threshold", 0, "minimum percentage of changed lines which must be covered")
+	exportDir := flag.String("coverage-export", "", "folder to write the combined coverage of every package to after each run, e.g. for editor coverage gutters")
+	exportFormats := flag.String("coverage-formats", autotest.ExportLCOV+","+autotest.ExportCobertura, "comma separated coverage export formats: lcov (lcov.info), cobertura (cobertura.xml) and html (coverage.html)")
+	changedTests := flag.Bool("changed-tests", true, "when only test files change, run the tests affected by the change before the whole package")
+	indexTests := flag.Bool("index", false, "run each test on its own in the background to record which tests cover each function")
+	selectTests := flag.Bool("select", false, "after a change, first run only the tests covering the changed lines, then the whole package. Implies -index")
+	indexFile := flag.String("index-file", "", "file the test coverage index is stored in. Defaults to a file in the user cache folder")
+	integrationCmd := flag.String("integration-cmd", "", "command run after each change with binaries built by go build -cover in PATH and GOCOVERDIR set. Its coverage is merged with the unit test coverage. Arguments with spaces can be quoted as in a shell")
+	integrationBuild := flag.String("integration-build", "./...", "comma separated main packages built for -integration-cmd")
+	consoleOutput := flag.Bool("console", true, "print the changes of each run to the console")
+	jsonReport := flag.String("json-report", "", "file to write every run event to as a line of JSON. Use - for stdout, which moves the console output to stderr")
+	gitBaseline := flag.Bool("git-baseline", false, "compare results with the tests run on a git ref in a temporary worktree instead of the first run")
+	baselineRef := flag.String("baseline-ref", "", "git ref used by -git-baseline. Defaults to the merge base with main")
+	flag.Usage = usage
+	flag.Parse()
+
+	autotest.CoverageDiffOptions.AbsoluteTolerance = float32(absTolerance)
+	autotest.CoverageDiffOptions.RelativeTolerance = float32(relTolerance)
+
+	switch *coverMode {
+	case "", autotest.CoverModeSet, autotest.CoverModeCount, autotest.CoverModeAtomic:
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -covermode", *coverMode)
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "replay":
+		os.Exit(replay(flag.Args()[1:]))
+	case "covered-by":
+		os.Exit(coveredBy(*indexFile, flag.Args()[1:]))
+	}
+
 	w, err := gobounce.New(gobounce.Options{RootFolders: []string{"."}, FolderExclusions: []string{"node_modules"}, FollowNewFolders: true}, 20*time.Millisecond)
 	if err != nil {
 		panic(err)
 	}
 
 	watchFolders := w.WatchFolders()
-	tmpDir, err := setupTempDir(watchFolders)
+	tmpDir := *artifactDir
+	if tmpDir == "" {
+		if tmpDir, err = setupTempDir(); err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(tmpDir)
+	}
+
+	workspace, err := autotest.LoadWorkspace(".")
 	if err != nil {
 		panic(err)
 	}
-	defer os.RemoveAll(tmpDir)
 
-	go handleChanges(w, tmpDir)
+	var baseline *autotest.GitBaseline
+	if *gitBaseline {
+		if baseline, err = autotest.NewGitBaseline(".", *baselineRef, tmpDir); err != nil {
+			panic(err)
+		}
+		defer baseline.Close()
+	}
+
+	var exporter *autotest.CoverageExporter
+	if *exportDir != "" {
+		if exporter, err = autotest.NewCoverageExporter(*exportDir, ".", splitList(*exportFormats)); err != nil {
+			panic(err)
+		}
+	}
+
+	var index *autotest.TestIndex
+	if *indexTests || *selectTests {
+		if index, err = loadIndex(*indexFile); err != nil {
+			panic(err)
+		}
+	}
+
+	var integration *autotest.IntegrationCoverage
+	if *integrationCmd != "" {
+		if integration, err = autotest.NewIntegrationCoverage(workspace.ModuleFor("."), splitList(*integrationBuild), *integrationCmd, tmpDir); err != nil {
+			panic(err)
+		}
+		integration.Mode = *coverMode
+	}
 
-	for _, folder := range watchFolders {
-		w.FolderChanged <- folder
+	reporters := autotest.NewMultiReporter()
+	var console *autotest.ConsoleReporter
+	if *consoleOutput {
+		consoleOut := os.Stdout
+		if *jsonReport == "-" { // keep stdout to the JSON lines
+			consoleOut = os.Stderr
+		}
+		console = autotest.NewConsoleReporter(consoleOut)
+		console.CRAPCutoff = float32(*crapCutoff)
+		console.HottestLines = *hottestLines
+		reporters = append(reporters, console)
+	}
+	if *jsonReport != "" {
+		out := os.Stdout
+		if *jsonReport != "-" {
+			if out, err = os.Create(*jsonReport); err != nil {
+				panic(err)
+			}
+			defer out.Close()
+		}
+		reporters = append(reporters, autotest.NewJSONReporter(out))
+	}
+
+	a := &app{
+		w:            w,
+		workspace:    workspace,
+		resolver:     autotest.NewChangeResolver(workspace, ".", watchFolders),
+		baseline:     baseline,
+		exporter:     exporter,
+		index:        index,
+		integration:  integration,
+		reporter:     reporters,
+		console:      console,
+		selectTests:  *selectTests,
+		watchFolders: watchFolders,
+		runOptions: autotest.RunOptions{
+			TempDir:          tmpDir,
+			Retain:           *retain,
+			Focus:            *focus,
+			CoverageExcludes: splitList(*excludes),
+			IncludeGenerated: *includeGenerated,
+			CoverPkg:         *coverPkg,
+			CoverMode:        *coverMode,
+			ChangedTests:     *changedTests,
+			PatchBase:        *patchBase,
+			PatchThreshold:   float32(*patchThreshold),
+		},
+		testsToTrack: make(chan *autotest.TestResult, 100), // track tests in parallel as they come in
+		integrations: make(chan *autotest.IntegrationResult, 1),
+	}
+	if *artifactDir == "" {
+		a.reporter.Message("artifacts are kept in " + tmpDir + " until autotest quits")
+	}
+	if baseline != nil {
+		a.reporter.Message("comparing with baseline commit " + baseline.Commit)
+	}
+	a.scheduler = autotest.NewScheduler(*concurrency, a.runTests)
+	defer a.scheduler.Close()
+	if index != nil {
+		a.indexer = autotest.NewScheduler(1, a.indexTests)
+		defer a.indexer.Close()
+	}
+	if integration != nil {
+		a.integrator = autotest.NewScheduler(1, a.runIntegration)
+		defer a.integrator.Close()
 	}
 
-	w.Start()
+	keys, restore := readKeys()
+	defer restore()
+
+	a.queueAll()
+	a.queueIntegration()
+	go w.Start()
+	a.handleChanges(keys)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  autotest [flags]           watch the current folder and run tests on change\n  autotest replay <log>      print a saved go test -json log. Use - to read from stdin\n  autotest covered-by <file>:<line or function>\n                             list the tests covering a line or function, from the -index\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func defaultConcurrency() int {
+	if n := runtime.NumCPU() / 2; n > 1 {
+		return n
+	}
+	return 1
 }
 
-func setupTempDir(watchFolders []string) (string, error) {
+func setupTempDir() (string, error) {
 	tmpDir := filepath.Join(os.TempDir(), "autotest-"+time.Now().Format("20060102-150405"))
 	if err := os.Mkdir(tmpDir, 0755); err != nil {
 		return "", err
 	}
-	for _, watchFolder := range watchFolders {
-		tmpWatchFolder := filepath.Join(tmpDir, watchFolder)
-		fmt.Println(tmpWatchFolder)
-	}
 	return tmpDir, nil
 }
 
-func handleChanges(w *gobounce.Filewatcher, tempDir string) {
+func (a *app) runTests(folder string) {
+	a.mutex.RLock()
+	runRegex := a.testFilter
+	a.mutex.RUnlock()
+
+	a.reporter.RunStarted(folder)
+	options := a.runOptions
+	options.Module, options.RunRegex = a.workspace.ModuleFor(folder), runRegex
+	if a.baseline != nil && !autotest.HasBaseline(folder) {
+		if baseline := a.baseline.Run(folder, options); baseline != nil {
+			autotest.SetBaseline(baseline)
+		}
+	}
+	result := autotest.RunTests(folder, options)
+	for _, status := range result.Status {
+		a.reporter.TestEvent(folder, status)
+	}
+	a.reporter.RunFinished(result)
+	a.testsToTrack <- result
+}
+
+func (a *app) indexTests(folder string) {
+	options := a.runOptions
+	options.Module = a.workspace.ModuleFor(folder)
+	if err := a.index.IndexFolder(folder, options); err != nil {
+		a.reporter.Error(fmt.Errorf("unable to index tests for %s: %w", folder, err))
+	}
+}
+
+// quit stops watching for changes and reports the last results of every folder
+func (a *app) quit() {
+	a.w.Close()
+	a.reporter.Summary(autotest.Summarize(autotest.LastResults()))
+}
+
+// runIntegration runs the integration command. The folder is only used to queue the run
+func (a *app) runIntegration(string) {
+	a.integrations <- a.integration.Run()
+}
+
+func (a *app) queueIntegration() {
+	if a.integrator != nil {
+		a.integrator.Queue(".", autotest.PrioritySweep)
+	}
+}
+
+// selectChangedTests picks the tests to run first after a change to a folder. Changed test files are analyzed
+// before falling back to the coverage index
+func (a *app) selectChangedTests(folder string) {
+	if a.runOptions.ChangedTests {
+		if tests, ok := autotest.ChangedTests(folder); ok {
+			autotest.SelectTests(folder, tests, autotest.RunModeChanged)
+			return
+		}
+	}
+	if a.selectTests {
+		if tests, ok := a.index.TestsForChanges(folder); ok {
+			autotest.SelectTests(folder, tests, autotest.RunModeSelected)
+		}
+	}
+}
+
+// queue schedules a folder unless it is excluded by the package filter
+func (a *app) queue(folder string, priority autotest.Priority) {
+	a.mutex.RLock()
+	filter := a.packageFilter
+	a.mutex.RUnlock()
+	if matchesPackageFilter(folder, filter) {
+		a.scheduler.Queue(folder, priority)
+	}
+}
+
+func (a *app) queueAll() {
+	for _, group := range a.workspace.GroupFolders(a.watchFolders) {
+		for _, folder := range group.Folders {
+			a.queue(folder, autotest.PrioritySweep)
+		}
+	}
+}
+
+func (a *app) queueFailing() {
+	for _, folder := range autotest.FailingFolders() {
+		if tests := autotest.FailingTests(folder); len(tests) != 0 {
+			autotest.FocusTests(folder, tests)
+		}
+		a.queue(folder, autotest.PriorityFailing)
+	}
+}
+
+// trackedResult is a run's result along with its changes since the baseline. diff is nil when nothing changed
+type trackedResult struct {
+	result *autotest.TestResult
+	diff   *autotest.TestResult
+}
+
+func (a *app) handleChanges(keys <-chan command) {
 	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
-	testsToTrack := make(chan *autotest.TestResult, 100) // track tests in parallel as they come in
-	testsToPrint := make(chan *autotest.TestResult)      // print one at a time
+	testsToReport := make(chan trackedResult) // report one at a time
 
 	for {
 		select {
-		case <-w.FileChanged:
-		case folder := <-w.FolderChanged:
-			go func() {
-				fmt.Println("\nrunning tests for", folder)
-				testsToTrack <- autotest.RunTests(folder, tempDir)
-			}()
-		case <-w.Closed:
+		case file := <-a.w.FileChanged:
+			folders := a.resolver.Resolve(file)
+			for _, folder := range folders {
+				a.selectChangedTests(folder)
+				a.queue(folder, autotest.PriorityChanged)
+			}
+			if len(folders) != 0 {
+				a.queueIntegration()
+			}
+		case <-a.w.FolderChanged: // resolved from the files changed within them. Deleted folders drop out of the module coverage by themselves
+		case <-a.w.Closed:
 			return
-		case <-w.Error:
-		case track := <-testsToTrack:
+		case <-a.w.Error:
+		case track := <-a.testsToTrack:
+			if autotest.UpdateFocus(track) {
+				a.queue(track.Folder, autotest.PriorityFailing)
+			}
+			if a.indexer != nil && track.Error == nil && !track.IsPartial() {
+				a.indexer.Queue(track.Folder, autotest.PrioritySweep)
+				for _, dependent := range a.index.Dependents(track.Folder) { // their coverage may have changed too
+					a.indexer.Queue(dependent, autotest.PrioritySweep)
+				}
+			}
 			go func() {
-				if print := autotest.Track(track); print != nil {
-					testsToPrint <- print
-				} else {
-					fmt.Println("unchanged")
+				if a.exporter != nil {
+					if err := a.exporter.Update(track); err != nil {
+						a.reporter.Error(fmt.Errorf("unable to export coverage: %w", err))
+					}
 				}
+				testsToReport <- trackedResult{track, autotest.Track(track)}
 			}()
-		case print := <-testsToPrint:
-			autotest.PrintTest(print)
+		case tracked := <-testsToReport:
+			if tracked.result.Error != nil {
+				a.reporter.BuildError(tracked.result)
+			} else {
+				if a.index != nil && tracked.diff != nil {
+					a.index.Annotate(tracked.diff)
+				}
+				a.reporter.CoverageDiff(tracked.result.Folder, tracked.diff)
+			}
+			a.printHelp()
+		case integration := <-a.integrations:
+			a.reporter.Integration(integration)
+			a.printHelp()
+		case cmd := <-keys:
+			if quit := a.handleCommand(cmd); quit {
+				a.quit()
+				return
+			}
 		case <-term:
-			w.Close()
+			a.quit()
 			return
 		}
 	}
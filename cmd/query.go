@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robarchibald/autotest"
+)
+
+func loadIndex(filename string) (*autotest.TestIndex, error) {
+	if filename == "" {
+		var err error
+		if filename, err = autotest.DefaultIndexFile("."); err != nil {
+			return nil, err
+		}
+	}
+	return autotest.LoadTestIndex(filename)
+}
+
+// coveredBy prints the tests covering a line or function and returns the exit code
+func coveredBy(indexFile string, args []string) int {
+	if len(args) != 1 || !strings.Contains(args[0], ":") {
+		fmt.Fprintln(os.Stderr, "usage: autotest covered-by <file>:<line or function>")
+		return 2
+	}
+	index, err := loadIndex(indexFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(index.Packages) == 0 {
+		fmt.Fprintln(os.Stderr, "the test index is empty. Run autotest -index to build it")
+		return 2
+	}
+
+	colon := strings.LastIndex(args[0], ":")
+	file, location := args[0][:colon], args[0][colon+1:]
+	start, err := strconv.Atoi(location)
+	end := start
+	if err != nil {
+		var ok bool
+		if start, end, ok = autotest.FunctionLines(file, location); !ok {
+			fmt.Fprintf(os.Stderr, "function %s not found in %s\n", location, file)
+			return 2
+		}
+	}
+	tests := index.CoveringTests(file, start, end)
+	if len(tests) == 0 {
+		fmt.Println("not covered by any test")
+		return 1
+	}
+	for _, test := range tests {
+		fmt.Println(test)
+	}
+	return 0
+}
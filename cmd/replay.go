@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/robarchibald/autotest"
+)
+
+// replay prints a saved go test -json log and returns the exit code
+func replay(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: autotest replay <log>")
+		return 2
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer f.Close()
+		r = f
+	}
+
+	results, err := autotest.ReplayLog(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	console := autotest.NewConsoleReporter(os.Stdout)
+	exitCode := 0
+	for _, result := range results {
+		console.PrintTest(result)
+		if result.HasFailures() {
+			exitCode = 1
+		}
+	}
+	console.Summary(autotest.Summarize(results))
+	return exitCode
+}
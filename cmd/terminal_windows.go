@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+// keysNeedEnter is true as the console stays in line mode
+const keysNeedEnter = true
+
+// makeRaw is not supported on Windows, so key commands must be followed by enter
+func makeRaw() (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,87 @@
+package autotest
+
+import (
+	"go/ast"
+	"go/token"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// getCyclomaticComplexity returns one plus the number of decision points in a function: if, for and range
+// statements, case and select clauses other than default, and the && and || operators. Function literals count
+// towards the function they are declared in
+func getCyclomaticComplexity(fn *ast.FuncDecl) int {
+	complexity := 1
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			if n.List != nil {
+				complexity++
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
+
+// getCRAP returns the Change Risk Anti-Patterns score of a function: complexity² × (1 - coverage)³ + complexity.
+// Fully covered functions score their complexity. Complex functions without tests score highest
+func getCRAP(complexity int, coveragePercent float32) float32 {
+	uncovered := 1 - float64(coveragePercent)/100
+	crap := math.Pow(float64(complexity), 2)*math.Pow(uncovered, 3) + float64(complexity)
+	rounded, _ := strconv.ParseFloat(formatFloat(crap, 1), 32)
+	return float32(rounded)
+}
+
+// addRiskScores sets the complexity and CRAP score of each function whose file is in the profile
+func addRiskScores(coverage []FunctionCoverage, profile *Profile, sources *sourceFiles) {
+	extents := make(map[string][]functionExtent)
+	for i, item := range coverage {
+		filename, ok := profile.Filenames[item.Path]
+		if !ok {
+			continue
+		}
+		if _, parsed := extents[filename]; !parsed {
+			extents[filename] = sources.functionExtents(filename, func(*ast.FuncDecl) bool { return true })
+		}
+		for _, fn := range extents[filename] {
+			if fn.Name == item.Function && fn.StartLine == item.LineNumber {
+				coverage[i].Complexity = fn.Complexity
+				coverage[i].CRAP = getCRAP(fn.Complexity, item.CoveragePercent)
+				break
+			}
+		}
+	}
+}
+
+// rankByRisk orders functions by their CRAP score, highest first. The total stays last
+func rankByRisk(coverage []FunctionCoverage) []FunctionCoverage {
+	ranked := append([]FunctionCoverage{}, coverage...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Filename == "total" || ranked[j].Filename == "total" {
+			return ranked[j].Filename == "total" && ranked[i].Filename != "total"
+		}
+		return ranked[i].CRAP > ranked[j].CRAP
+	})
+	return ranked
+}
+
+func hasRiskScores(coverage []FunctionCoverage) bool {
+	for _, item := range coverage {
+		if item.Complexity != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package autotest
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCyclomaticComplexity(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "a.go", `package a
+func simple() {}
+func branches(a, b int, c chan int) {
+	if a > 0 && b > 0 || a < 0 {
+	} else if b == 0 {
+	}
+	for i := 0; i < a; i++ {}
+	for range []int{} {}
+	switch a {
+	case 1, 2:
+	case 3:
+	default:
+	}
+	select {
+	case <-c:
+	default:
+	}
+	func() {
+		if a == b {}
+	}()
+}`, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, getCyclomaticComplexity(file.Decls[0].(*ast.FuncDecl)))
+	assert.Equal(t, 11, getCyclomaticComplexity(file.Decls[1].(*ast.FuncDecl)))
+}
+
+func TestGetCRAP(t *testing.T) {
+	assert.Equal(t, float32(5), getCRAP(5, 100))
+	assert.Equal(t, float32(30), getCRAP(5, 0))
+	assert.Equal(t, float32(8.1), getCRAP(5, 50))
+}
+
+func TestAddRiskScores(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	assert.Nil(t, os.WriteFile(filename, []byte("package a\n\nfunc Get(a int) int {\n\tif a > 0 {\n\t\treturn a\n\t}\n\treturn 0\n}\n"), 0644))
+	coverage := []FunctionCoverage{
+		{Filename: "a.go", Path: "example.com/a/a.go", Function: "Get", LineNumber: 3, CoveragePercent: 0},
+		{Filename: "b.go", Path: "example.com/a/b.go", Function: "Put", LineNumber: 3},
+		{Filename: "total", Function: "(statements)"},
+	}
+	addRiskScores(coverage, &Profile{Filenames: map[string]string{"example.com/a/a.go": filename}}, newSourceFiles())
+	assert.Equal(t, 2, coverage[0].Complexity)
+	assert.Equal(t, float32(6), coverage[0].CRAP)
+	assert.Equal(t, 0, coverage[1].Complexity)
+	assert.Equal(t, 0, coverage[2].Complexity)
+}
+
+func TestRankByRisk(t *testing.T) {
+	ranked := rankByRisk([]FunctionCoverage{
+		{Function: "a", CRAP: 2},
+		{Filename: "total"},
+		{Function: "b", CRAP: 10},
+		{Function: "c", CRAP: 2},
+	})
+	names := []string{}
+	for _, item := range ranked {
+		names = append(names, item.Function+item.Filename)
+	}
+	assert.Equal(t, []string{"b", "a", "c", "total"}, names)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"math"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,56 +12,139 @@ import (
 	"github.com/logrusorgru/aurora"
 )
 
-var Println = fmt.Println
-var Printf = fmt.Printf
-var Print = fmt.Print
-
-// PrintTest is used to print a single test results to the console
-func PrintTest(result *TestResult) {
-	margin := (80 - len(result.Folder)) / 2
-	Println()
-	Println(strings.Repeat("-", margin), result.Folder, strings.Repeat("-", margin))
+// PrintTest prints the results of a test run
+func (c *ConsoleReporter) PrintTest(result *TestResult) {
+	title := result.Folder
+	switch result.Mode {
+	case RunModeFocus:
+		title += " [focus: failing tests only]"
+	case RunModeSelected:
+		title += " [selected: tests covering changes]"
+	case RunModeChanged:
+		title += " [changed tests only]"
+	case RunModeConfirm:
+		title += " [confirming full package]"
+	case RunModeFiltered:
+		title += " [filtered: matching tests only]"
+	}
+	c.printTitle(title)
 	if result.Error != nil {
-		printBuildFailure(result.Error)
+		c.printBuildFailure(result.Error)
 	}
 	if len(result.Status) != 0 {
-		printTestEvents(result.Status, result.Error != nil)
+		c.printTestEvents(result.Status, result.ModulePath, result.Error != nil)
+		c.printSkipped(result.Status, result.NewlySkipped)
+	}
+	if c.ShowCoverage && len(result.Coverage) != 0 {
+		c.printCoverage(result.Coverage)
+	}
+	if c.ShowCoverage && c.HottestLines != 0 && result.Profile != nil && result.Profile.hasCounts() {
+		c.printHottestLines(getHottestLines(result.Profile, c.HottestLines), result.ModulePath)
+	}
+	if c.ShowCoverage && result.Patch != nil && result.Patch.Total != 0 {
+		c.printPatchCoverage(result.Patch, result.ModulePath)
+	}
+}
+
+// PrintIntegration prints the outcome of an integration run. The output of the command is only shown when it fails
+func (c *ConsoleReporter) PrintIntegration(result *IntegrationResult) {
+	c.printTitle("integration coverage")
+	if result.Error != nil {
+		c.println(aurora.Red(result.Error.Error()))
 	}
-	if len(result.Coverage) != 0 {
-		printCoverage(result.Coverage)
+	if result.ExitCode != 0 {
+		if result.Output != "" {
+			c.println(aurora.Gray(10, result.Output))
+		}
+		c.println(aurora.Bold(aurora.Red(fmt.Sprintf("integration command failed with exit code %d", result.ExitCode))))
+	}
+	if result.Profile != nil {
+		c.println("integration coverage:", printPercent(float64(getStatementCoverage(result.Profile.Blocks))), "(merged into the coverage of each package from its next run)")
 	}
 }
 
-func printTestEvents(groupedEvents []TestStatus, showAll bool) {
-	groupedEvents, maxPackageLen, maxTestLen := getFilteredListAndLengths(groupedEvents, showAll)
+func (c *ConsoleReporter) printTestEvents(groupedEvents []TestStatus, modulePath string, showAll bool) {
+	groupedEvents, maxPackageLen, maxTestLen := getFilteredListAndLengths(groupedEvents, modulePath, showAll)
 	if len(groupedEvents) != 0 {
-		printHeader("--- Test Results ---", "Time  ", rightPad("Package", maxPackageLen), rightPad("Test", maxTestLen), "Status")
+		c.printHeader("--- Test Results ---", "Time  ", rightPad("Package", maxPackageLen), rightPad("Test", maxTestLen), "Status")
 	}
 	for _, event := range groupedEvents {
-		Println(printElapsedTime(event.Elapsed), rightPad(getPackage(event.Package), maxPackageLen), aurora.BrightWhite(rightPad(getTestName(event.Test), maxTestLen)), printTestResult(event.TestResult), printOutput(event.Output))
+		c.println(printElapsedTime(event.Elapsed), rightPad(getPackage(event.Package, modulePath), maxPackageLen), aurora.BrightWhite(rightPad(getTestName(event.Test), maxTestLen)), printTestResult(event.TestResult), printOutput(event.Output))
+	}
+}
+
+// printSkipped lists the skipped tests grouped by the reason given to t.Skip. Tests which ran in the previous run
+// are marked as new
+func (c *ConsoleReporter) printSkipped(groupedEvents []TestStatus, newlySkipped []string) {
+	reasons := []string{}
+	byReason := make(map[string][]string)
+	for _, event := range groupedEvents {
+		if event.TestResult != "skip" || event.Test == "" {
+			continue
+		}
+		reason := event.SkipReason
+		if reason == "" {
+			reason = "(no reason given)"
+		}
+		if _, ok := byReason[reason]; !ok {
+			reasons = append(reasons, reason)
+		}
+		byReason[reason] = append(byReason[reason], event.Test)
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	isNew := make(map[string]bool)
+	for _, test := range newlySkipped {
+		isNew[test] = true
+	}
+	c.printHeader("--- Skipped ---", "Reason / Test")
+	for _, reason := range reasons {
+		c.println(aurora.Yellow(reason))
+		for _, test := range byReason[reason] {
+			if isNew[test] {
+				c.println("  ", aurora.BrightWhite(test), aurora.Bold(aurora.Yellow("(newly skipped)")))
+			} else {
+				c.println("  ", aurora.BrightWhite(test))
+			}
+		}
+	}
+}
+
+// printTitle prints a title centered in an 80 character line of dashes. Longer titles are printed without dashes
+func (c *ConsoleReporter) printTitle(title string) {
+	margin := (80 - len(title)) / 2
+	if margin < 0 {
+		margin = 0
 	}
+	c.println()
+	c.println(strings.Repeat("-", margin), title, strings.Repeat("-", margin))
 }
 
-func printHeader(header string, columns ...string) {
+func (c *ConsoleReporter) printHeader(header string, columns ...string) {
 	totalWidth := 0
 	for _, column := range columns {
 		totalWidth += len(column) + 1
 	}
-	Println(strings.Repeat(" ", (totalWidth-len(header))/2), aurora.Blue(header))
+	margin := (totalWidth - len(header)) / 2
+	if margin < 0 {
+		margin = 0
+	}
+	c.println(strings.Repeat(" ", margin), aurora.Blue(header))
 	for _, column := range columns {
-		Print(aurora.Gray(15, column+" "))
+		c.print(aurora.Gray(15, column+" "))
 	}
-	Println()
+	c.println()
 }
 
-func getFilteredListAndLengths(groupedEvents []TestStatus, showAll bool) ([]TestStatus, int, int) {
+func getFilteredListAndLengths(groupedEvents []TestStatus, modulePath string, showAll bool) ([]TestStatus, int, int) {
 	maxPackageLen := 0
 	maxTestLen := len("[package]")
 	filteredList := []TestStatus{}
 	for _, event := range groupedEvents {
-		if event.Test == "" || showAll || event.Elapsed > 0.1 {
+		if event.Test == "" || showAll || event.Elapsed > 0.1 || event.TestResult == "fail" {
 			filteredList = append(filteredList, event)
-			if l := len(getPackage(event.Package)); l > maxPackageLen {
+			if l := len(getPackage(event.Package, modulePath)); l > maxPackageLen {
 				maxPackageLen = l + 1
 			}
 			if l := len(event.Test); l > maxTestLen {
@@ -73,7 +157,7 @@ func getFilteredListAndLengths(groupedEvents []TestStatus, showAll bool) ([]Test
 
 var buildFailParse = regexp.MustCompile(`(^.*?):(\d*):(\d*):(.*)$`) // <file info><line number>:<column number>:<error message>
 
-func printBuildFailure(err error) {
+func (c *ConsoleReporter) printBuildFailure(err error) {
 	r := bufio.NewScanner(strings.NewReader(err.Error()))
 	for r.Scan() {
 		line := r.Text()
@@ -87,7 +171,7 @@ func printBuildFailure(err error) {
 			if lastColon != -1 {
 				pathInfo = strings.TrimSpace(pathInfo[lastColon+1:])
 			}
-			Printf("Error in %s at line %s, column %s\n%s\n", pathInfo, aurora.Blue(parsedLine[2]), aurora.Blue(parsedLine[3]), aurora.Red(strings.TrimSpace(parsedLine[4])))
+			c.printf("Error in %s at line %s, column %s\n%s\n", pathInfo, aurora.Blue(parsedLine[2]), aurora.Blue(parsedLine[3]), aurora.Red(strings.TrimSpace(parsedLine[4])))
 		}
 	}
 }
@@ -106,7 +190,17 @@ func formatFloat(num float64, decimalPlaces int) string {
 	return strconv.FormatFloat(math.Round(num*math.Pow(10.0, float64(decimalPlaces)))/math.Pow(10.0, float64(decimalPlaces)), 'f', decimalPlaces, 64)
 }
 
-func getPackage(pkg string) string {
+// getPackage returns the package name relative to its module. The module root package is shown by its last path
+// element. Without a module path, a github.com/org/repo style prefix is assumed
+func getPackage(pkg, modulePath string) string {
+	if modulePath != "" {
+		if pkg == modulePath {
+			return path.Base(modulePath)
+		}
+		if strings.HasPrefix(pkg, modulePath+"/") {
+			return pkg[len(modulePath)+1:]
+		}
+	}
 	split := strings.Split(pkg, "/")
 	if len(split) < 3 {
 		return pkg
@@ -131,35 +225,197 @@ func printTestResult(status string) string {
 		return aurora.Green("PASS").String()
 	case "fail":
 		return aurora.Red("FAIL").String()
-	case "skipped":
-		return aurora.Yellow("SKIPPED").String()
+	case "skip":
+		return aurora.Yellow("SKIP").String()
 	}
 	return status
 }
 
 func printOutput(output string) string {
-	if len(output) > 0 {
-		return aurora.Gray(10, fmt.Sprintf("\noutput:%s\n", output)).String()
+	if len(output) == 0 {
+		return ""
 	}
-	return ""
+	if segments := parseAssertions(output); hasAssertions(segments) {
+		return aurora.Gray(10, "\noutput:").String() + strings.Join(renderSegments(segments), "\n") + "\n"
+	}
+	return aurora.Gray(10, fmt.Sprintf("\noutput:%s\n", output)).String()
 }
 
-func printCoverage(coverageItems []FunctionCoverage) {
-	maxFilenameLen, maxFunctionLen, not100Percent := getCoverageLengths(coverageItems)
-	if not100Percent == 0 {
+func (c *ConsoleReporter) printCoverage(coverageItems []FunctionCoverage) {
+	maxFilenameLen, maxFunctionLen, notFullyCovered := getCoverageLengths(coverageItems)
+	if notFullyCovered == 0 {
 		return
 	}
-	printHeader("--- Code Coverage ---", rightPad("Filename", maxFilenameLen), rightPad("Function", maxFunctionLen), "Coverage")
+	withRisk := hasRiskScores(coverageItems)
+	percentHeaders := getPercentHeaders(coverageItems)
+	headers := append([]string{rightPad("Filename", maxFilenameLen), rightPad("Function", maxFunctionLen)}, percentHeaders...)
+	if withRisk {
+		headers = append(headers, "Complexity", "CRAP")
+		coverageItems = rankByRisk(coverageItems)
+	}
+	c.printHeader("--- Code Coverage ---", headers...)
 	for _, coverage := range coverageItems {
-		if coverage.CoveragePercent == 100 {
+		if isFullyCovered(coverage) || withRisk && coverage.Filename != "total" && coverage.CRAP < c.CRAPCutoff {
+			continue
+		}
+		columns := []interface{}{rightPad(coverage.Filename, maxFilenameLen), rightPad(coverage.Function, maxFunctionLen)}
+		percents := getPercentColumns(coverage)
+		for i, percent := range percents {
+			if i < len(percents)-1 || coverage.Complexity != 0 { // the last column is only padded when followed by the risk columns
+				columns = append(columns, padPercent(percent, percentHeaders[i]))
+			} else {
+				columns = append(columns, printPercent(float64(percent)))
+			}
+		}
+		if coverage.Complexity != 0 {
+			columns = append(columns, rightPad(strconv.Itoa(coverage.Complexity), len("Complexity")), printCRAP(coverage.CRAP))
+		}
+		if coverage.Nondeterministic {
+			columns = append(columns, aurora.Magenta("(nondeterministic)"))
+		}
+		if len(coverage.Branches) != 0 {
+			columns = append(columns, printBranchesTaken(coverage))
+		}
+		if len(coverage.CoveredBy) != 0 {
+			columns = append(columns, aurora.Gray(12, "covered by "+joinLimited(coverage.CoveredBy, 3)))
+		}
+		c.println(columns...)
+		c.printUntakenBranches(coverage.Branches, maxUntakenBranches)
+	}
+}
+
+// printHottestLines lists the lines which ran most often, to spot loops doing more work than expected
+func (c *ConsoleReporter) printHottestLines(lines []HotLine, modulePath string) {
+	if len(lines) == 0 {
+		return
+	}
+	maxLocationLen, maxCountLen := len("Line"), len("Runs")
+	locations := []string{}
+	for _, line := range lines {
+		location := fmt.Sprintf("%s:%d", getPackage(line.File, modulePath), line.Line)
+		locations = append(locations, location)
+		if len(location) > maxLocationLen {
+			maxLocationLen = len(location)
+		}
+		if l := len(strconv.Itoa(line.Count)); l > maxCountLen {
+			maxCountLen = l
+		}
+	}
+	c.printHeader("--- Hottest lines ---", rightPad("Line", maxLocationLen), rightPad("Runs", maxCountLen), "Code")
+	for i, line := range lines {
+		count := strings.Repeat(" ", maxCountLen-len(strconv.Itoa(line.Count))) + strconv.Itoa(line.Count)
+		c.println(rightPad(locations[i], maxLocationLen), aurora.Yellow(count), aurora.Gray(12, line.Source))
+	}
+}
+
+// printPatchCoverage lists the changed lines which weren't covered in each file, followed by the percentage of
+// changed lines covered
+func (c *ConsoleReporter) printPatchCoverage(patch *PatchCoverage, modulePath string) {
+	maxFilenameLen := len("Filename")
+	for _, file := range patch.Files {
+		if l := len(getPackage(file.File, modulePath)); l > maxFilenameLen {
+			maxFilenameLen = l
+		}
+	}
+	if patch.Covered != patch.Total {
+		c.printHeader("--- Changed lines coverage ---", rightPad("Filename", maxFilenameLen), "Uncovered lines")
+		for _, file := range patch.Files {
+			if len(file.Uncovered) != 0 {
+				c.println(rightPad(getPackage(file.File, modulePath), maxFilenameLen), aurora.BrightRed(formatLineRanges(file.Uncovered)))
+			}
+		}
+	}
+	summary := fmt.Sprintf("(%d of %d changed lines since %s)", patch.Covered, patch.Total, patch.Base)
+	if patch.BelowThreshold() {
+		c.println("patch coverage:", printPercent(float64(patch.Percent())), summary, aurora.Bold(aurora.Red("FAIL: below the "+formatFloat(float64(patch.Threshold), 1)+"% threshold")))
+		return
+	}
+	c.println("patch coverage:", printPercent(float64(patch.Percent())), summary)
+}
+
+// isFullyCovered returns true when every statement and every known branch of a function ran
+func isFullyCovered(coverage FunctionCoverage) bool {
+	return coverage.CoveragePercent == 100 && coverage.BranchesTaken() == len(coverage.Branches)
+}
+
+func printBranchesTaken(coverage FunctionCoverage) string {
+	text := fmt.Sprintf("%d of %d branches taken", coverage.BranchesTaken(), len(coverage.Branches))
+	if coverage.BranchesTaken() != len(coverage.Branches) {
+		return aurora.Yellow(text).String()
+	}
+	return text
+}
+
+// number of untaken branches listed below each function
+const maxUntakenBranches = 5
+
+// printUntakenBranches lists the branches which didn't run below the function, up to limit
+func (c *ConsoleReporter) printUntakenBranches(branches []Branch, limit int) {
+	untaken := 0
+	for _, branch := range branches {
+		if branch.Taken {
 			continue
 		}
-		Println(rightPad(coverage.Filename, maxFilenameLen), rightPad(coverage.Function, maxFunctionLen), printPercent(float64(coverage.CoveragePercent)))
+		if untaken++; untaken <= limit {
+			c.println("   ", aurora.Gray(12, fmt.Sprintf("line %d: %s not taken", branch.Line, branch.Condition)))
+		}
+	}
+	if untaken > limit {
+		c.println("   ", aurora.Gray(12, fmt.Sprintf("and %d more", untaken-limit)))
+	}
+}
+
+func getPercentHeaders(coverageItems []FunctionCoverage) []string {
+	headers := []string{"Coverage"}
+	if len(coverageItems) == 0 {
+		return headers
+	}
+	if coverageItems[0].CrossPackage {
+		headers = []string{"Own tests", "Any tests"}
 	}
+	if coverageItems[0].Integration {
+		headers = append(headers, "Integration")
+	}
+	return headers
+}
+
+// getPercentColumns returns the coverage percentages of a function in the order of getPercentHeaders
+func getPercentColumns(coverage FunctionCoverage) []float32 {
+	percents := []float32{coverage.CoveragePercent}
+	if coverage.CrossPackage {
+		percents = append(percents, coverage.AnyTestsPercent)
+	}
+	if coverage.Integration {
+		percents = append(percents, coverage.IntegrationPercent)
+	}
+	return percents
+}
+
+func padPercent(percent float32, header string) string {
+	return printPercent(float64(percent)) + strings.Repeat(" ", len(header)-len(getPercentText(float64(percent))))
+}
+
+// printCRAP colors scores of 30 and over, the usual threshold for code which needs more tests or simplifying, red
+func printCRAP(crap float32) string {
+	text := formatFloat(float64(crap), 1)
+	if crap >= 30 {
+		return aurora.BrightRed(text).String()
+	} else if crap >= 15 {
+		return aurora.Yellow(text).String()
+	}
+	return text
+}
+
+// joinLimited joins the first limit items and counts the rest, e.g. "TestA, TestB and 3 more"
+func joinLimited(items []string, limit int) string {
+	if len(items) <= limit {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:limit], ", "), len(items)-limit)
 }
 
 func getCoverageLengths(coverageItems []FunctionCoverage) (int, int, int) {
-	var maxFilenameLen, maxFunctionLen, not100Percent int
+	var maxFilenameLen, maxFunctionLen, notFullyCovered int
 	for _, coverage := range coverageItems {
 		if l := len(coverage.Filename); l > maxFilenameLen {
 			maxFilenameLen = l
@@ -167,18 +423,25 @@ func getCoverageLengths(coverageItems []FunctionCoverage) (int, int, int) {
 		if l := len(coverage.Function); l > maxFunctionLen {
 			maxFunctionLen = l
 		}
-		if coverage.CoveragePercent != 100 {
-			not100Percent++
+		if !isFullyCovered(coverage) {
+			notFullyCovered++
 		}
 	}
-	return maxFilenameLen, maxFunctionLen, not100Percent
+	return maxFilenameLen, maxFunctionLen, notFullyCovered
 }
 
 func printPercent(percent float64) string {
 	if percent == 100 {
-		return aurora.Green("100%").String()
+		return aurora.Green(getPercentText(percent)).String()
 	} else if percent > 75 {
-		return aurora.Yellow(formatFloat(percent, 1) + "%").String()
+		return aurora.Yellow(getPercentText(percent)).String()
+	}
+	return aurora.BrightRed(getPercentText(percent)).String()
+}
+
+func getPercentText(percent float64) string {
+	if percent == 100 {
+		return "100%"
 	}
-	return aurora.BrightRed(formatFloat(percent, 1) + "%").String()
+	return formatFloat(percent, 1) + "%"
 }
@@ -2,6 +2,7 @@ package autotest
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -43,13 +44,11 @@ func TestPrintTest(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			p := &fakePrinter{}
-			Println = p.Println
-			Printf = p.Printf
-			Print = p.Print
+			var printed strings.Builder
+			c := NewConsoleReporter(&printed)
 
-			PrintTest(tt.result)
-			assert.Equal(t, tt.wantText, p.printed.String())
+			c.PrintTest(tt.result)
+			assert.Equal(t, tt.wantText, printed.String())
 		})
 	}
 }
@@ -63,26 +62,166 @@ func getColumns(columns []string) string {
 }
 
 func TestPrintBuildFailure(t *testing.T) {
-	p := &fakePrinter{}
-	Println = p.Println
-	Printf = p.Printf
-	Print = p.Print
-	printBuildFailure(fmt.Errorf(buildFailure))
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.printBuildFailure(fmt.Errorf(buildFailure))
 	assert.Equal(t,
 		fmt.Sprintf("Error in autotest/console.go at line %s, column %s\n%s\n", aurora.Blue("66"), aurora.Blue("2"), aurora.Red("expected ';', found x (and 2 more errors)")),
-		p.printed.String())
+		printed.String())
 }
 
-type fakePrinter struct {
-	printed strings.Builder
+func TestGetPackage(t *testing.T) {
+	assert.Equal(t, "autotest/cmd", getPackage("github.com/robarchibald/autotest/cmd", ""))
+	assert.Equal(t, "short", getPackage("short", ""))
+	assert.Equal(t, "cmd", getPackage("example.com/autotest/cmd", "example.com/autotest"))
+	assert.Equal(t, "autotest", getPackage("example.com/autotest", "example.com/autotest"))
+	assert.Equal(t, "api/v2/store", getPackage("example.com/mod/api/v2/store", "example.com/mod"))
+	assert.Equal(t, "pkg", getPackage("example.com/other/pkg", "example.com/mod"))
 }
 
-func (p *fakePrinter) Println(a ...interface{}) (n int, err error) {
-	return p.printed.WriteString(fmt.Sprintln(a...))
+func TestPrintTestMode(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.PrintTest(&TestResult{Folder: "folderName", Mode: RunModeFocus})
+	margin := strings.Repeat("-", (80-len("folderName [focus: failing tests only]"))/2)
+	assert.Equal(t, "\n"+margin+" folderName [focus: failing tests only] "+margin+"\n", printed.String())
 }
-func (p *fakePrinter) Printf(format string, a ...interface{}) (n int, err error) {
-	return p.printed.WriteString(fmt.Sprintf(format, a...))
+
+func TestPrintTestLongFolder(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	folder := strings.Repeat("nested/", 12) + "folder"
+	c.PrintTest(&TestResult{Folder: folder, Mode: RunModeConfirm})
+	assert.Equal(t, "\n "+folder+" [confirming full package] \n", printed.String())
+}
+
+func TestGetFilteredListAndLengths(t *testing.T) {
+	events := []TestStatus{{Package: "pkg", Test: "TestFast", TestResult: "pass", Elapsed: 0.01}, {Package: "pkg", Test: "TestFail", TestResult: "fail"}, {Package: "pkg"}}
+	filtered, _, _ := getFilteredListAndLengths(events, "", false)
+	assert.Equal(t, events[1:], filtered)
+	filtered, _, _ = getFilteredListAndLengths(events, "", true)
+	assert.Equal(t, events, filtered)
+}
+
+func TestPrintSkipped(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.printSkipped([]TestStatus{
+		{Test: "TestA", TestResult: "skip", SkipReason: "short mode"},
+		{Test: "TestB", TestResult: "pass"},
+		{Test: "TestC", TestResult: "skip"},
+		{Test: "TestD", TestResult: "skip", SkipReason: "short mode"},
+	}, []string{"TestD"})
+	assert.Equal(t, " "+aurora.Blue("--- Skipped ---").String()+"\n"+getColumns([]string{"Reason / Test"})+"\n"+
+		aurora.Yellow("short mode").String()+"\n"+
+		"   "+aurora.BrightWhite("TestA").String()+"\n"+
+		"   "+aurora.BrightWhite("TestD").String()+" "+aurora.Bold(aurora.Yellow("(newly skipped)")).String()+"\n"+
+		aurora.Yellow("(no reason given)").String()+"\n"+
+		"   "+aurora.BrightWhite("TestC").String()+"\n", printed.String())
+
+	printed.Reset()
+	c.printSkipped([]TestStatus{{Test: "TestB", TestResult: "pass"}}, nil)
+	assert.Equal(t, "", printed.String())
+	assert.Equal(t, aurora.Yellow("SKIP").String(), printTestResult("skip"))
+}
+
+func TestPrintPatchCoverage(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	patch := &PatchCoverage{Base: "HEAD", Covered: 3, Total: 4, Threshold: 80, Files: []PatchFile{
+		{File: "example.com/m/pkg/a.go", Covered: 1, Total: 2, Uncovered: []int{7}},
+		{File: "example.com/m/pkg/b.go", Covered: 2, Total: 2},
+	}}
+	c.printPatchCoverage(patch, "example.com/m")
+	assert.Equal(t, " "+aurora.Blue("--- Changed lines coverage ---").String()+"\n"+getColumns([]string{"Filename", "Uncovered lines"})+"\n"+
+		"pkg/a.go "+aurora.BrightRed("7").String()+"\n"+
+		"patch coverage: "+printPercent(75)+" (3 of 4 changed lines since HEAD) "+aurora.Bold(aurora.Red("FAIL: below the 80.0% threshold")).String()+"\n", printed.String())
+
+	printed.Reset()
+	c.printPatchCoverage(&PatchCoverage{Base: "main", Covered: 2, Total: 2}, "example.com/m")
+	assert.Equal(t, "patch coverage: "+printPercent(100)+" (2 of 2 changed lines since main)\n", printed.String())
+}
+
+func TestPrintCoverageCrossPackage(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.printCoverage([]FunctionCoverage{
+		{Filename: "a.go", Function: "Get", CoveragePercent: 100, AnyTestsPercent: 100, CrossPackage: true},
+		{Filename: "a.go", Function: "Put", CoveragePercent: 0, AnyTestsPercent: 80, CrossPackage: true},
+		{Filename: "a.go", Function: "Del", CoveragePercent: 50, AnyTestsPercent: 100, CrossPackage: true},
+	})
+	assert.Equal(t, "         "+aurora.Blue("--- Code Coverage ---").String()+"\n"+getColumns([]string{"Filename", "Function", "Own tests", "Any tests"})+"\n"+
+		"a.go Put "+printPercent(0)+"     "+" "+printPercent(80)+"\n"+
+		"a.go Del "+printPercent(50)+"    "+" "+printPercent(100)+"\n", printed.String())
 }
-func (p *fakePrinter) Print(a ...interface{}) (n int, err error) {
-	return p.printed.WriteString(fmt.Sprint(a...))
+
+func TestPrintCoverageCoveredBy(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.printCoverage([]FunctionCoverage{{Filename: "a.go", Function: "Put", CoveragePercent: 50, CoveredBy: []string{"TestA", "TestB"}}})
+	assert.Contains(t, printed.String(), "a.go Put "+printPercent(50)+" "+aurora.Gray(12, "covered by TestA, TestB").String()+"\n")
+	assert.Equal(t, "A, B, C and 2 more", joinLimited([]string{"A", "B", "C", "D", "E"}, 3))
+}
+
+func TestPrintCoverageByRisk(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.CRAPCutoff = 5
+	c.printCoverage([]FunctionCoverage{
+		{Filename: "a.go", Function: "Get", CoveragePercent: 50, Complexity: 2, CRAP: 2.5},
+		{Filename: "a.go", Function: "Put", CoveragePercent: 0, Complexity: 6, CRAP: 42},
+		{Filename: "a.go", Function: "Del", CoveragePercent: 50, Complexity: 4, CRAP: 6},
+		{Filename: "total", Function: "(statements)", CoveragePercent: 40},
+	})
+	assert.Equal(t, "              "+aurora.Blue("--- Code Coverage ---").String()+"\n"+
+		getColumns([]string{"Filename", "Function    ", "Coverage", "Complexity", "CRAP"})+"\n"+
+		"a.go  Put          "+printPercent(0)+"    "+" 6         "+" "+printCRAP(42)+"\n"+
+		"a.go  Del          "+printPercent(50)+"   "+" 4         "+" "+printCRAP(6)+"\n"+
+		"total (statements) "+printPercent(40)+"\n", printed.String())
+}
+
+func TestPrintIntegration(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.PrintIntegration(&IntegrationResult{ExitCode: 1, Output: "FAIL: calc sub", Profile: &Profile{Blocks: []ProfileBlock{{NumStmt: 1, Count: 1}, {StartLine: 2, NumStmt: 1}}}})
+	assert.Equal(t, "\n"+strings.Repeat("-", 30)+" integration coverage "+strings.Repeat("-", 30)+"\n"+
+		aurora.Gray(10, "FAIL: calc sub").String()+"\n"+
+		aurora.Bold(aurora.Red("integration command failed with exit code 1")).String()+"\n"+
+		"integration coverage: "+printPercent(50)+" (merged into the coverage of each package from its next run)\n", printed.String())
+
+	printed.Reset()
+	c.printCoverage([]FunctionCoverage{{Filename: "a.go", Function: "Put", CoveragePercent: 0, IntegrationPercent: 100, Integration: true}})
+	assert.Contains(t, printed.String(), "a.go Put "+printPercent(0)+"    "+" "+printPercent(100)+"\n")
+}
+
+func TestPrintHottestLines(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.printHottestLines([]HotLine{
+		{File: "example.com/m/pkg/a.go", Line: 12, Count: 1500, Source: "sum += i"},
+		{File: "example.com/m/pkg/a.go", Line: 3, Count: 2, Source: "return sum"},
+	}, "example.com/m")
+	assert.Equal(t, " "+aurora.Blue("--- Hottest lines ---").String()+"\n"+getColumns([]string{"Line       ", "Runs", "Code"})+"\n"+
+		"pkg/a.go:12 "+aurora.Yellow("1500").String()+" "+aurora.Gray(12, "sum += i").String()+"\n"+
+		"pkg/a.go:3  "+aurora.Yellow("   2").String()+" "+aurora.Gray(12, "return sum").String()+"\n", printed.String())
+}
+
+func TestPrintCoverageBranches(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	branches := []Branch{{Line: 4, Condition: "if a > 0", Taken: true}}
+	for line := 5; line < 12; line++ {
+		branches = append(branches, Branch{Line: line, Condition: "switch a: case " + strconv.Itoa(line)})
+	}
+	c.printCoverage([]FunctionCoverage{
+		{Filename: "a.go", Function: "Get", CoveragePercent: 100, Branches: branches[:1]},
+		{Filename: "a.go", Function: "Put", CoveragePercent: 100, Branches: branches},
+	})
+	text := printed.String()
+	assert.NotContains(t, text, "Get", "fully covered functions are left out")
+	assert.Contains(t, text, "a.go Put "+printPercent(100)+" "+aurora.Yellow("1 of 8 branches taken").String()+"\n"+
+		"    "+aurora.Gray(12, "line 5: switch a: case 5 not taken").String()+"\n")
+	assert.Contains(t, text, "    "+aurora.Gray(12, "line 9: switch a: case 9 not taken").String()+"\n"+
+		"    "+aurora.Gray(12, "and 2 more").String()+"\n")
+	assert.Equal(t, "1 of 1 branches taken", printBranchesTaken(FunctionCoverage{Branches: branches[:1]}))
 }
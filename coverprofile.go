@@ -0,0 +1,174 @@
+package autotest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile contains the blocks of a coverage profile written by go test -coverprofile
+type Profile struct {
+	Mode      string
+	Blocks    []ProfileBlock
+	Filenames map[string]string // absolute location on disk of each file, when known
+}
+
+// ProfileBlock is a single block of statements from a coverage profile
+type ProfileBlock struct {
+	File      string // import path of the file, e.g. github.com/org/repo/pkg/file.go
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+// blockKey identifies a block by its position, so the same block from several runs can be matched
+type blockKey struct {
+	File                                 string
+	StartLine, StartCol, EndLine, EndCol int
+}
+
+func (b ProfileBlock) key() blockKey {
+	return blockKey{b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol}
+}
+
+func readProfile(filename string) (*Profile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseProfile(f)
+}
+
+// parseProfile reads a coverage profile. Lines have the form "file:startLine.startCol,endLine.endCol numStmt count"
+func parseProfile(r io.Reader) (*Profile, error) {
+	profile := &Profile{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode:") {
+			profile.Mode = strings.TrimSpace(line[len("mode:"):])
+			continue
+		}
+		block, err := parseProfileLine(line)
+		if err != nil {
+			return nil, err
+		}
+		profile.Blocks = append(profile.Blocks, block)
+	}
+	return profile, scanner.Err()
+}
+
+func parseProfileLine(line string) (ProfileBlock, error) {
+	var block ProfileBlock
+	colon := strings.LastIndex(line, ":")
+	if colon == -1 {
+		return block, fmt.Errorf("invalid coverage profile line: %s", line)
+	}
+	block.File = line[:colon]
+	_, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d", &block.StartLine, &block.StartCol, &block.EndLine, &block.EndCol, &block.NumStmt, &block.Count)
+	if err != nil {
+		return block, fmt.Errorf("invalid coverage profile line: %s: %w", line, err)
+	}
+	return block, nil
+}
+
+// Files returns the distinct files in the profile in sorted order
+func (p *Profile) Files() []string {
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, block := range p.Blocks {
+		if !seen[block.File] {
+			seen[block.File] = true
+			files = append(files, block.File)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// getStatementCoverage returns the percentage of statements covered by the blocks. Blocks which appear more than
+// once, as in profiles merged from several runs, are only counted once and covered if any run covered them
+func getStatementCoverage(blocks []ProfileBlock) float32 {
+	statements := make(map[blockKey]int)
+	covered := make(map[blockKey]bool)
+	for _, block := range blocks {
+		key := block.key()
+		statements[key] = block.NumStmt
+		covered[key] = covered[key] || block.Count > 0
+	}
+	var total, hit int
+	for key, numStmt := range statements {
+		total += numStmt
+		if covered[key] {
+			hit += numStmt
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	percent, _ := strconv.ParseFloat(formatFloat(100*float64(hit)/float64(total), 1), 32)
+	return float32(percent)
+}
+
+// resolveProfileFile returns the location on disk of a file from a coverage profile. Files in the module are
+// found relative to the module folder. Without a module, the file is assumed to be in the tested folder
+func resolveProfileFile(module *Module, folder, file string) string {
+	if module != nil && strings.HasPrefix(file, module.Path+"/") {
+		return filepath.Join(module.Dir, filepath.FromSlash(file[len(module.Path)+1:]))
+	}
+	return filepath.Join(folder, path.Base(file))
+}
+
+func (p *Profile) resolveFilenames(module *Module, folder string) {
+	p.Filenames = make(map[string]string)
+	for _, file := range p.Files() {
+		filename, err := filepath.Abs(resolveProfileFile(module, folder, file))
+		if err == nil {
+			p.Filenames[file] = filename
+		}
+	}
+}
+
+// mergeProfiles combines the blocks of several profiles. Counts of the same block are added together, or in set
+// mode, the block is covered when any profile covered it
+func mergeProfiles(profiles ...*Profile) *Profile {
+	merged := &Profile{Filenames: make(map[string]string)}
+	index := make(map[blockKey]int)
+	for _, profile := range profiles {
+		if merged.Mode == "" {
+			merged.Mode = profile.Mode
+		}
+		for file, filename := range profile.Filenames {
+			merged.Filenames[file] = filename
+		}
+		for _, block := range profile.Blocks {
+			key := block.key()
+			i, ok := index[key]
+			switch {
+			case !ok:
+				index[key] = len(merged.Blocks)
+				merged.Blocks = append(merged.Blocks, block)
+			case merged.Mode == "set":
+				if block.Count > merged.Blocks[i].Count {
+					merged.Blocks[i].Count = block.Count
+				}
+			default:
+				merged.Blocks[i].Count += block.Count
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,71 @@
+package autotest
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testProfile = `mode: set
+example.com/mod/pkg/a.go:3.13,5.2 1 1
+example.com/mod/pkg/a.go:7.13,9.2 3 0
+example.com/mod/pkg/b.go:3.13,5.2 1 1
+`
+
+func TestParseProfile(t *testing.T) {
+	profile, err := parseProfile(strings.NewReader(testProfile))
+	require.NoError(t, err)
+	assert.Equal(t, "set", profile.Mode)
+	require.Equal(t, 3, len(profile.Blocks))
+	assert.Equal(t, ProfileBlock{File: "example.com/mod/pkg/a.go", StartLine: 7, StartCol: 13, EndLine: 9, EndCol: 2, NumStmt: 3}, profile.Blocks[1])
+	assert.Equal(t, []string{"example.com/mod/pkg/a.go", "example.com/mod/pkg/b.go"}, profile.Files())
+
+	_, err = parseProfile(strings.NewReader("mode: set\nbogus"))
+	assert.Error(t, err)
+	_, err = parseProfile(strings.NewReader("mode: set\nfile.go:1.1,2.2 x y"))
+	assert.Error(t, err)
+	_, err = readProfile(filepath.Join(t.TempDir(), "missing.out"))
+	assert.Error(t, err)
+}
+
+func TestGetStatementCoverage(t *testing.T) {
+	profile, _ := parseProfile(strings.NewReader(testProfile))
+	assert.Equal(t, float32(40), getStatementCoverage(profile.Blocks))
+	assert.Equal(t, float32(0), getStatementCoverage(nil))
+
+	merged := append(profile.Blocks, ProfileBlock{File: "example.com/mod/pkg/a.go", StartLine: 7, StartCol: 13, EndLine: 9, EndCol: 2, NumStmt: 3, Count: 1})
+	assert.Equal(t, float32(100), getStatementCoverage(merged))
+}
+
+func TestResolveProfileFile(t *testing.T) {
+	module := &Module{Path: "example.com/mod", Dir: filepath.FromSlash("/src/mod")}
+	assert.Equal(t, filepath.FromSlash("/src/mod/pkg/a.go"), resolveProfileFile(module, "pkg", "example.com/mod/pkg/a.go"))
+	assert.Equal(t, filepath.Join("pkg", "a.go"), resolveProfileFile(nil, "pkg", "example.com/mod/pkg/a.go"))
+}
+
+func TestMergeProfiles(t *testing.T) {
+	a := &Profile{Mode: "count", Filenames: map[string]string{"m/a.go": "/m/a.go"}, Blocks: []ProfileBlock{
+		{File: "m/a.go", StartLine: 1, EndLine: 2, NumStmt: 1, Count: 2},
+		{File: "m/a.go", StartLine: 3, EndLine: 4, NumStmt: 1, Count: 0},
+	}}
+	b := &Profile{Mode: "count", Filenames: map[string]string{"m/b.go": "/m/b.go"}, Blocks: []ProfileBlock{
+		{File: "m/a.go", StartLine: 1, EndLine: 2, NumStmt: 1, Count: 3},
+		{File: "m/b.go", StartLine: 1, EndLine: 2, NumStmt: 1, Count: 1},
+	}}
+	merged := mergeProfiles(a, b)
+	assert.Equal(t, "count", merged.Mode)
+	assert.Equal(t, map[string]string{"m/a.go": "/m/a.go", "m/b.go": "/m/b.go"}, merged.Filenames)
+	assert.Equal(t, []ProfileBlock{
+		{File: "m/a.go", StartLine: 1, EndLine: 2, NumStmt: 1, Count: 5},
+		{File: "m/a.go", StartLine: 3, EndLine: 4, NumStmt: 1, Count: 0},
+		{File: "m/b.go", StartLine: 1, EndLine: 2, NumStmt: 1, Count: 1},
+	}, merged.Blocks)
+	assert.Equal(t, 2, a.Blocks[0].Count, "profiles being merged are unchanged")
+
+	a.Mode, b.Mode = "set", "set"
+	a.Blocks[0].Count, b.Blocks[0].Count = 1, 1
+	assert.Equal(t, 1, mergeProfiles(a, b).Blocks[0].Count)
+}
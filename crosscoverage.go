@@ -0,0 +1,139 @@
+package autotest
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// profiles of the last -coverpkg run of each folder. Together they make up the coverage of the whole module
+var folderProfiles = make(map[string]*Profile)
+var folderProfileMutex sync.Mutex
+
+// getCoverPkgArg returns the -coverpkg pattern matching every package in the module
+func getCoverPkgArg(module *Module) string {
+	if module == nil {
+		return "./..."
+	}
+	return module.Path + "/..."
+}
+
+// recordFolderProfile saves the profile of a folder's run and returns the profiles of every folder merged together
+// into the coverage of the whole module. Folders which no longer exist are left out
+func recordFolderProfile(folder string, profile *Profile) *Profile {
+	folderProfileMutex.Lock()
+	defer folderProfileMutex.Unlock()
+	folderProfiles[folder] = profile
+	folders := make([]string, 0, len(folderProfiles))
+	for folder := range folderProfiles {
+		if _, err := os.Stat(folder); err != nil {
+			delete(folderProfiles, folder)
+			continue
+		}
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	profiles := []*Profile{}
+	for _, folder := range folders {
+		profiles = append(profiles, folderProfiles[folder])
+	}
+	return mergeProfiles(profiles...)
+}
+
+// ForgetFolder leaves the coverage of a folder's tests out of the module coverage of later -coverpkg runs, e.g.
+// when it no longer matches the package filter. The folder is the watched folder name its tests were run with.
+// Deleted folders don't need to be forgotten as they are left out when the next profile is recorded
+func ForgetFolder(folder string) {
+	folderProfileMutex.Lock()
+	delete(folderProfiles, folder)
+	folderProfileMutex.Unlock()
+}
+
+// folderProfile returns the part of a profile covering the files within folder
+func (p *Profile) folderProfile(folder string) *Profile {
+	dir, err := filepath.Abs(folder)
+	if err != nil {
+		return p
+	}
+	own := &Profile{Mode: p.Mode, Filenames: make(map[string]string)}
+	for _, block := range p.Blocks {
+		if filename, ok := p.Filenames[block.File]; ok && filepath.Dir(filename) == dir {
+			own.Blocks = append(own.Blocks, block)
+			own.Filenames[block.File] = filename
+		}
+	}
+	return own
+}
+
+// ModuleProfileName is the file in RunOptions.TempDir that the merged profile of every -coverpkg run is written to
+const ModuleProfileName = "module.cover.out"
+
+// writeProfile writes a profile in the format of go test -coverprofile
+func writeProfile(w io.Writer, profile *Profile) error {
+	mode := profile.Mode
+	if mode == "" {
+		mode = "set"
+	}
+	if _, err := fmt.Fprintf(w, "mode: %s\n", mode); err != nil {
+		return err
+	}
+	for _, b := range profile.Blocks {
+		if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCrossPackageCoverage keeps the functions of the files in own, the profile of the tested package, and adds
+// the coverage of each function by the tests of every package from the module profile
+func getCrossPackageCoverage(coverage []FunctionCoverage, own, module *Profile, sources *sourceFiles) []FunctionCoverage {
+	blocksByFile := make(map[string][]ProfileBlock)
+	for _, block := range module.Blocks {
+		if _, ok := own.Filenames[block.File]; ok {
+			blocksByFile[block.File] = append(blocksByFile[block.File], block)
+		}
+	}
+	extents := make(map[string][]functionExtent)
+	filtered := []FunctionCoverage{}
+	for _, item := range coverage {
+		switch filename, ok := own.Filenames[item.Path]; {
+		case item.Filename == "total":
+			allBlocks := []ProfileBlock{}
+			for _, blocks := range blocksByFile {
+				allBlocks = append(allBlocks, blocks...)
+			}
+			item.AnyTestsPercent = getStatementCoverage(allBlocks)
+		case ok:
+			if _, parsed := extents[item.Path]; !parsed {
+				extents[item.Path] = sources.functionExtents(filename, func(*ast.FuncDecl) bool { return true })
+			}
+			item.AnyTestsPercent = getFunctionCoverage(blocksByFile[item.Path], extents[item.Path], item)
+		default: // function from another package
+			continue
+		}
+		item.CrossPackage = true
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+func getFunctionCoverage(blocks []ProfileBlock, extents []functionExtent, item FunctionCoverage) float32 {
+	for _, fn := range extents {
+		if fn.Name != item.Function || fn.StartLine != item.LineNumber {
+			continue
+		}
+		inFunction := []ProfileBlock{}
+		for _, block := range blocks {
+			if block.StartLine >= fn.StartLine && block.EndLine <= fn.EndLine {
+				inFunction = append(inFunction, block)
+			}
+		}
+		return getStatementCoverage(inFunction)
+	}
+	return item.CoveragePercent
+}
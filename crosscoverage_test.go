@@ -0,0 +1,81 @@
+package autotest
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCoverPkgArg(t *testing.T) {
+	assert.Equal(t, "./...", getCoverPkgArg(nil))
+	assert.Equal(t, "example.com/m/...", getCoverPkgArg(&Module{Path: "example.com/m"}))
+}
+
+func TestWriteProfile(t *testing.T) {
+	profile, err := parseProfile(bytes.NewBufferString(testProfile))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, writeProfile(&buf, profile))
+	written, err := parseProfile(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, profile, written)
+}
+
+func TestGetCrossPackageCoverage(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"api/api.go":     "package api\n\nfunc Handle() {\n\tprintln()\n}\n",
+		"store/store.go": "package store\n\nfunc Get() int {\n\treturn 1\n}\n\nfunc Put() {\n\tx := 1\n\t_ = x\n}\n",
+	})
+	module := &Module{Path: "example.com/m", Dir: root}
+	storeFolder := filepath.Join(root, "store")
+
+	// the store package's own tests only cover Get. The api package's tests also cover Put
+	storeRun := &Profile{Mode: "set", Blocks: []ProfileBlock{
+		{File: "example.com/m/store/store.go", StartLine: 3, StartCol: 16, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 1},
+		{File: "example.com/m/store/store.go", StartLine: 7, StartCol: 12, EndLine: 10, EndCol: 2, NumStmt: 2, Count: 0},
+		{File: "example.com/m/api/api.go", StartLine: 3, StartCol: 12, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 0},
+	}}
+	storeRun.resolveFilenames(module, storeFolder)
+	apiRun := &Profile{Mode: "set", Blocks: []ProfileBlock{
+		{File: "example.com/m/store/store.go", StartLine: 3, StartCol: 16, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 0},
+		{File: "example.com/m/store/store.go", StartLine: 7, StartCol: 12, EndLine: 10, EndCol: 2, NumStmt: 2, Count: 1},
+		{File: "example.com/m/api/api.go", StartLine: 3, StartCol: 12, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 1},
+	}}
+	apiRun.resolveFilenames(module, filepath.Join(root, "api"))
+
+	recordFolderProfile(filepath.Join(root, "api"), apiRun)
+	merged := recordFolderProfile(storeFolder, storeRun)
+	assert.Equal(t, []int{1, 1, 1}, []int{merged.Blocks[0].Count, merged.Blocks[1].Count, merged.Blocks[2].Count})
+
+	own := storeRun.folderProfile(storeFolder)
+	assert.Equal(t, storeRun.Blocks[:2], own.Blocks)
+
+	coverage := getCrossPackageCoverage([]FunctionCoverage{
+		{Path: "example.com/m/api/api.go", Filename: "api.go", Function: "Handle", LineNumber: 3, CoveragePercent: 0},
+		{Path: "example.com/m/store/store.go", Filename: "store.go", Function: "Get", LineNumber: 3, CoveragePercent: 100},
+		{Path: "example.com/m/store/store.go", Filename: "store.go", Function: "Put", LineNumber: 7, CoveragePercent: 0},
+		{Filename: "total", Function: "(statements)", CoveragePercent: 25},
+	}, own, merged, newSourceFiles())
+	assert.Equal(t, []FunctionCoverage{
+		{Path: "example.com/m/store/store.go", Filename: "store.go", Function: "Get", LineNumber: 3, CoveragePercent: 100, AnyTestsPercent: 100, CrossPackage: true},
+		{Path: "example.com/m/store/store.go", Filename: "store.go", Function: "Put", LineNumber: 7, CoveragePercent: 0, AnyTestsPercent: 100, CrossPackage: true},
+		{Filename: "total", Function: "(statements)", CoveragePercent: 25, AnyTestsPercent: 100, CrossPackage: true},
+	}, coverage)
+}
+
+func TestRecordFolderProfileForget(t *testing.T) {
+	defer func() { folderProfiles = make(map[string]*Profile) }()
+	root := t.TempDir()
+	block := func(file string) *Profile {
+		return &Profile{Mode: "set", Blocks: []ProfileBlock{{File: file, StartLine: 1, EndLine: 2, NumStmt: 1, Count: 1}}}
+	}
+	recordFolderProfile(filepath.Join(root, "deleted"), block("example.com/m/deleted/a.go"))
+	recordFolderProfile(filepath.Join(root, "excluded"), block("example.com/m/excluded/a.go"))
+	ForgetFolder(filepath.Join(root, "excluded"))
+	merged := recordFolderProfile(root, block("example.com/m/a.go"))
+	assert.Equal(t, []ProfileBlock{block("example.com/m/a.go").Blocks[0]}, merged.Blocks)
+}
@@ -0,0 +1,101 @@
+package autotest
+
+import (
+	"regexp"
+)
+
+const (
+	diffEqual   = ' '
+	diffRemoved = '-'
+	diffAdded   = '+'
+)
+
+type diffItem struct {
+	Kind byte
+	Text string
+}
+
+// maxDiffCells caps the size of the longest common subsequence table, which needs a cell for each pair of items
+const maxDiffCells = 1 << 20
+
+// getDiff returns the shortest edit script turning a into b using the longest common subsequence. The common
+// prefix and suffix are matched first. When the items between them would need a table of more than maxDiffCells,
+// they are reported as all removed and then all added
+func getDiff(a, b []string) []diffItem {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	diff := []diffItem{}
+	for _, text := range a[:prefix] {
+		diff = append(diff, diffItem{diffEqual, text})
+	}
+	diff = append(diff, getLCSDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		diff = append(diff, diffItem{diffEqual, text})
+	}
+	return diff
+}
+
+func getLCSDiff(a, b []string) []diffItem {
+	diff := []diffItem{}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, text := range a {
+			diff = append(diff, diffItem{diffRemoved, text})
+		}
+		for _, text := range b {
+			diff = append(diff, diffItem{diffAdded, text})
+		}
+		return diff
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, diffItem{diffEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, diffItem{diffRemoved, a[i]})
+			i++
+		default:
+			diff = append(diff, diffItem{diffAdded, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, diffItem{diffRemoved, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, diffItem{diffAdded, b[j]})
+	}
+	return diff
+}
+
+var wordSplit = regexp.MustCompile(`\w+|\s+|[^\w\s]`)
+
+// splitWords splits text into words, whitespace and punctuation so that joining the result gives back the text
+func splitWords(text string) []string {
+	return wordSplit.FindAllString(text, -1)
+}
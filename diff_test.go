@@ -0,0 +1,37 @@
+package autotest
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiff(t *testing.T) {
+	diff := getDiff([]string{"a", "b", "c", "d"}, []string{"a", "c", "e", "d"})
+	assert.Equal(t, []diffItem{{' ', "a"}, {'-', "b"}, {' ', "c"}, {'+', "e"}, {' ', "d"}}, diff)
+	assert.Equal(t, []diffItem{{'-', "a"}}, getDiff([]string{"a"}, nil))
+	assert.Equal(t, []diffItem{{'+', "a"}}, getDiff(nil, []string{"a"}))
+	assert.Equal(t, []diffItem{}, getDiff(nil, nil))
+}
+
+func TestGetDiffLarge(t *testing.T) {
+	a, b := []string{"same"}, []string{"same"}
+	for i := 0; i < 2000; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	diff := getDiff(append(a, "end"), append(b, "end"))
+	assert.Equal(t, 4002, len(diff))
+	assert.Equal(t, diffItem{' ', "same"}, diff[0])
+	assert.Equal(t, diffItem{'-', "a0"}, diff[1])
+	assert.Equal(t, diffItem{'+', "b0"}, diff[2001])
+	assert.Equal(t, diffItem{' ', "end"}, diff[4001])
+}
+
+func TestSplitWords(t *testing.T) {
+	words := splitWords(`main.S{A:1, B:"x"}`)
+	assert.Equal(t, []string{"main", ".", "S", "{", "A", ":", "1", ",", " ", "B", ":", `"`, "x", `"`, "}"}, words)
+	assert.Equal(t, `main.S{A:1, B:"x"}`, strings.Join(words, ""))
+}
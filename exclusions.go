@@ -0,0 +1,245 @@
+package autotest
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// NoCoverComment excludes a function from coverage when it appears in the function's doc comment
+const NoCoverComment = "//autotest:nocover"
+
+var generatedHeader = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// coverageFilter decides which files and functions are left out of coverage results
+type coverageFilter struct {
+	module           *Module
+	folder           string
+	includeGenerated bool
+	excludes         []*regexp.Regexp
+	files            map[string]*fileExclusions
+	sources          *sourceFiles // shared with the rest of the run's coverage so each file is parsed once
+}
+
+type fileExclusions struct {
+	Excluded  bool             // the whole file is excluded
+	Functions []functionExtent // functions marked with NoCoverComment
+}
+
+type functionExtent struct {
+	Name       string
+	StartLine  int
+	EndLine    int
+	Complexity int // cyclomatic complexity
+}
+
+func newCoverageFilter(module *Module, folder string, options RunOptions) *coverageFilter {
+	f := &coverageFilter{module: module, folder: folder, includeGenerated: options.IncludeGenerated, files: make(map[string]*fileExclusions), sources: newSourceFiles()}
+	for _, pattern := range options.CoverageExcludes {
+		f.excludes = append(f.excludes, globToRegexp(pattern))
+	}
+	return f
+}
+
+// applyCoverageExclusions removes excluded files and functions from the coverage results and recalculates the
+// total from the profile blocks which are left
+func applyCoverageExclusions(coverage []FunctionCoverage, profile *Profile, filter *coverageFilter) []FunctionCoverage {
+	blocks := filter.includedBlocks(profile.Blocks)
+	filtered := []FunctionCoverage{}
+	for _, item := range coverage {
+		switch {
+		case item.Filename == "total":
+			item.CoveragePercent = getStatementCoverage(blocks)
+		case filter.isExcludedFunction(item):
+			continue
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+// includedBlocks returns the blocks which aren't excluded from coverage
+func (f *coverageFilter) includedBlocks(blocks []ProfileBlock) []ProfileBlock {
+	included := []ProfileBlock{}
+	for _, block := range blocks {
+		if !f.isExcludedBlock(block) {
+			included = append(included, block)
+		}
+	}
+	return included
+}
+
+func (f *coverageFilter) isExcludedBlock(block ProfileBlock) bool {
+	exclusions := f.getFileExclusions(block.File)
+	if exclusions.Excluded {
+		return true
+	}
+	for _, fn := range exclusions.Functions {
+		if block.StartLine >= fn.StartLine && block.EndLine <= fn.EndLine {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *coverageFilter) isExcludedFunction(item FunctionCoverage) bool {
+	if item.Path == "" {
+		return false
+	}
+	exclusions := f.getFileExclusions(item.Path)
+	if exclusions.Excluded {
+		return true
+	}
+	for _, fn := range exclusions.Functions {
+		if fn.Name == item.Function && fn.StartLine == item.LineNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *coverageFilter) getFileExclusions(file string) *fileExclusions {
+	if exclusions, ok := f.files[file]; ok {
+		return exclusions
+	}
+	exclusions := &fileExclusions{Excluded: f.isExcludedPath(file)}
+	if !exclusions.Excluded {
+		filename := resolveProfileFile(f.module, f.folder, file)
+		exclusions.Excluded = !f.includeGenerated && isGeneratedFile(filename)
+		if !exclusions.Excluded {
+			exclusions.Functions = getNoCoverFunctions(f.sources, filename)
+		}
+	}
+	f.files[file] = exclusions
+	return exclusions
+}
+
+// isExcludedPath matches the exclude patterns against the module relative path and the file name
+func (f *coverageFilter) isExcludedPath(file string) bool {
+	relative := file
+	if f.module != nil {
+		relative = strings.TrimPrefix(file, f.module.Path+"/")
+	}
+	for _, exclude := range f.excludes {
+		if exclude.MatchString(relative) || exclude.MatchString(path.Base(file)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGeneratedFile checks for the standard "// Code generated ... DO NOT EDIT." comment before the package clause
+func isGeneratedFile(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if generatedHeader.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
+
+// getNoCoverFunctions returns the functions whose doc comment contains NoCoverComment
+func getNoCoverFunctions(sources *sourceFiles, filename string) []functionExtent {
+	return sources.functionExtents(filename, func(fn *ast.FuncDecl) bool {
+		return fn.Doc != nil && hasNoCoverComment(fn.Doc)
+	})
+}
+
+// getFunctionExtents returns the lines of the functions in a file which match include
+func getFunctionExtents(filename string, include func(fn *ast.FuncDecl) bool) []functionExtent {
+	return newSourceFiles().functionExtents(filename, include)
+}
+
+// sourceFiles parses each file once, so the exclusions, risk scores and branches of a run share the syntax trees
+type sourceFiles struct {
+	fset  *token.FileSet
+	files map[string]*ast.File
+}
+
+func newSourceFiles() *sourceFiles {
+	return &sourceFiles{fset: token.NewFileSet(), files: make(map[string]*ast.File)}
+}
+
+// parse returns the syntax tree of a file with its comments, or nil when it can't be parsed
+func (s *sourceFiles) parse(filename string) *ast.File {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	if file, ok := s.files[filename]; ok {
+		return file
+	}
+	file, err := parser.ParseFile(s.fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		file = nil
+	}
+	s.files[filename] = file
+	return file
+}
+
+// functionExtents returns the lines of the functions in a file which match include
+func (s *sourceFiles) functionExtents(filename string, include func(fn *ast.FuncDecl) bool) []functionExtent {
+	file := s.parse(filename)
+	if file == nil {
+		return nil
+	}
+	functions := []functionExtent{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !include(fn) {
+			continue
+		}
+		functions = append(functions, functionExtent{Name: fn.Name.Name, StartLine: s.fset.Position(fn.Pos()).Line, EndLine: s.fset.Position(fn.End()).Line, Complexity: getCyclomaticComplexity(fn)})
+	}
+	return functions
+}
+
+func hasNoCoverComment(doc *ast.CommentGroup) bool {
+	for _, comment := range doc.List {
+		if strings.HasPrefix(comment.Text, NoCoverComment) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a slash separated glob pattern to a regular expression. "**" matches any number of
+// folders, "*" and "?" match within a single path element
+func globToRegexp(pattern string) *regexp.Regexp {
+	var buf strings.Builder
+	buf.WriteString("^")
+	pattern = filepath.ToSlash(pattern)
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			buf.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			buf.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			buf.WriteString("[^/]*")
+		case pattern[i] == '?':
+			buf.WriteString("[^/]")
+		default:
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.MustCompile(buf.String())
+}
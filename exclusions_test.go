@@ -0,0 +1,87 @@
+package autotest
+
+import (
+	"go/ast"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyCoverageExclusions(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"pkg/a.go": "package pkg\n\nfunc Used() {\n\tprintln()\n}\n\n//autotest:nocover\nfunc Debug() {\n\tprintln()\n}\n",
+		"pkg/b.go": "// Code generated by stringer. DO NOT EDIT.\n\npackage pkg\n\nfunc String() {\n\tprintln()\n}\n",
+	})
+	module := &Module{Path: "example.com/mod", Dir: root}
+	profile, err := parseProfile(strings.NewReader(`mode: set
+example.com/mod/pkg/a.go:3.13,5.2 1 1
+example.com/mod/pkg/a.go:8.14,10.2 3 0
+example.com/mod/pkg/b.go:5.15,7.2 1 0
+`))
+	require.NoError(t, err)
+	coverage := []FunctionCoverage{
+		{Path: "example.com/mod/pkg/a.go", Filename: "a.go", Function: "Used", LineNumber: 3, CoveragePercent: 100},
+		{Path: "example.com/mod/pkg/a.go", Filename: "a.go", Function: "Debug", LineNumber: 8, CoveragePercent: 0},
+		{Path: "example.com/mod/pkg/b.go", Filename: "b.go", Function: "String", LineNumber: 5, CoveragePercent: 0},
+		{Filename: "total", Function: "(statements)", CoveragePercent: 20},
+	}
+
+	filtered := applyCoverageExclusions(coverage, profile, newCoverageFilter(module, filepath.Join(root, "pkg"), RunOptions{}))
+	assert.Equal(t, []FunctionCoverage{coverage[0], {Filename: "total", Function: "(statements)", CoveragePercent: 100}}, filtered)
+
+	filtered = applyCoverageExclusions(coverage, profile, newCoverageFilter(module, filepath.Join(root, "pkg"), RunOptions{IncludeGenerated: true}))
+	assert.Equal(t, []FunctionCoverage{coverage[0], coverage[2], {Filename: "total", Function: "(statements)", CoveragePercent: 50}}, filtered)
+
+	filtered = applyCoverageExclusions(coverage, profile, newCoverageFilter(module, filepath.Join(root, "pkg"), RunOptions{IncludeGenerated: true, CoverageExcludes: []string{"pkg/b.go"}}))
+	assert.Equal(t, []FunctionCoverage{coverage[0], {Filename: "total", Function: "(statements)", CoveragePercent: 100}}, filtered)
+}
+
+func TestIsGeneratedFile(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"gen.go":     "// Code generated by protoc-gen-go. DO NOT EDIT.\n// source: api.proto\n\npackage api\n",
+		"notgen.go":  "package api\n\n// Code generated by protoc-gen-go. DO NOT EDIT.\n",
+		"similar.go": "// Code generated by hand, please edit.\npackage api\n",
+	})
+	assert.True(t, isGeneratedFile(filepath.Join(root, "gen.go")))
+	assert.False(t, isGeneratedFile(filepath.Join(root, "notgen.go")))
+	assert.False(t, isGeneratedFile(filepath.Join(root, "similar.go")))
+	assert.False(t, isGeneratedFile(filepath.Join(root, "missing.go")))
+}
+
+func TestSourceFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.go": "package a\n\n//autotest:nocover\nfunc A() {}\n\nfunc B() {}\n", "bad.go": "package"})
+	sources := newSourceFiles()
+	file := sources.parse(filepath.Join(root, "a.go"))
+	require.NotNil(t, file)
+	writeFiles(t, root, map[string]string{"a.go": "package a\n"})
+	assert.Same(t, file, sources.parse(filepath.Join(root, "a.go")), "each file is parsed once")
+	assert.Len(t, sources.functionExtents(filepath.Join(root, "a.go"), func(*ast.FuncDecl) bool { return true }), 2)
+	assert.Equal(t, []functionExtent{{Name: "A", StartLine: 4, EndLine: 4, Complexity: 1}}, getNoCoverFunctions(sources, filepath.Join(root, "a.go")))
+	assert.Nil(t, sources.parse(filepath.Join(root, "bad.go")))
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*_string.go", "kind_string.go", true},
+		{"*_string.go", "pkg/kind_string.go", false},
+		{"**/mocks/*.go", "mocks/store.go", true},
+		{"**/mocks/*.go", "api/v2/mocks/store.go", true},
+		{"**/mocks/*.go", "api/mocks/deep/store.go", false},
+		{"api/**", "api/v2/store.go", true},
+		{"a?.go", "ab.go", true},
+		{"a.go", "abgo", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, globToRegexp(tt.pattern).MatchString(tt.path), tt.pattern+" "+tt.path)
+	}
+}
@@ -0,0 +1,281 @@
+package autotest
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coverage export formats
+const (
+	ExportLCOV      = "lcov"      // written to lcov.info
+	ExportCobertura = "cobertura" // written to cobertura.xml
+	ExportHTML      = "html"      // written to coverage.html
+)
+
+var exportFilenames = map[string]string{ExportLCOV: "lcov.info", ExportCobertura: "cobertura.xml", ExportHTML: "coverage.html"}
+
+// CoverageExporter keeps the latest coverage profile of every folder and writes the combined coverage of all of
+// them in each format after every run
+type CoverageExporter struct {
+	Dir     string
+	Root    string // Cobertura file names are relative to Root
+	Formats []string
+
+	mutex    sync.Mutex
+	profiles map[string]*Profile
+}
+
+// NewCoverageExporter creates dir and returns an exporter writing the formats to it
+func NewCoverageExporter(dir, root string, formats []string) (*CoverageExporter, error) {
+	for _, format := range formats {
+		if _, ok := exportFilenames[format]; !ok {
+			return nil, fmt.Errorf("unknown coverage export format %s", format)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &CoverageExporter{Dir: dir, Root: root, Formats: formats, profiles: make(map[string]*Profile)}, nil
+}
+
+// Update replaces the coverage of the result's folder and rewrites the exported files. Results without coverage,
+// like failed builds and focused runs, leave the previous coverage of the folder in place
+func (e *CoverageExporter) Update(result *TestResult) error {
+	if result.Profile == nil {
+		return nil
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.profiles[result.Folder] = result.Profile
+
+	folders := make([]string, 0, len(e.profiles))
+	for folder := range e.profiles {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	profiles := []*Profile{}
+	for _, folder := range folders {
+		profiles = append(profiles, e.profiles[folder])
+	}
+	merged := mergeProfiles(profiles...)
+
+	now := time.Now()
+	for _, format := range e.Formats {
+		filename := filepath.Join(e.Dir, exportFilenames[format])
+		err := writeFileAtomic(filename, func(w io.Writer) error {
+			switch format {
+			case ExportLCOV:
+				return writeLCOV(w, merged)
+			case ExportHTML:
+				return writeHTML(w, merged, e.Root, now)
+			}
+			return writeCobertura(w, merged, e.Root, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file which replaces filename once it is complete, so editors watching
+// the file never read a partial export
+func writeFileAtomic(filename string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	buf := bufio.NewWriter(f)
+	if err := write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// getLineCounts returns the execution count of each line with statements in each file. A line in several blocks
+// has the highest count of those blocks
+func getLineCounts(profile *Profile) map[string]map[int]int {
+	counts := make(map[string]map[int]int)
+	for _, block := range profile.Blocks {
+		if block.NumStmt == 0 {
+			continue
+		}
+		lines := counts[block.File]
+		if lines == nil {
+			lines = make(map[int]int)
+			counts[block.File] = lines
+		}
+		for line := block.StartLine; line <= block.EndLine; line++ {
+			if count, ok := lines[line]; !ok || block.Count > count {
+				lines[line] = block.Count
+			}
+		}
+	}
+	return counts
+}
+
+func sortedLines(lines map[int]int) []int {
+	sorted := make([]int, 0, len(lines))
+	for line := range lines {
+		sorted = append(sorted, line)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+// exportFilename returns the file name on disk when it is known and the import path otherwise
+func exportFilename(profile *Profile, file string) string {
+	if filename, ok := profile.Filenames[file]; ok {
+		return filename
+	}
+	return file
+}
+
+// writeLCOV writes a tracefile with a record of line counts for each file
+func writeLCOV(w io.Writer, profile *Profile) error {
+	counts := getLineCounts(profile)
+	for _, file := range profile.Files() {
+		lines, ok := counts[file]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "TN:\nSF:%s\n", exportFilename(profile, file))
+		hit := 0
+		for _, line := range sortedLines(lines) {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, lines[line])
+			if lines[line] > 0 {
+				hit++
+			}
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      float64            `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity float64          `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity float64         `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// writeCobertura writes a Cobertura XML report with a package for each Go package and a class for each file.
+// File names within root are written relative to it
+func writeCobertura(w io.Writer, profile *Profile, root string, timestamp time.Time) error {
+	counts := getLineCounts(profile)
+	report := coberturaCoverage{Timestamp: timestamp.UnixNano() / int64(time.Millisecond), Sources: []string{root}}
+	packages := make(map[string]int) // index of each package in the report
+	packageLines := make(map[string][2]int)
+	for _, file := range profile.Files() {
+		lines, ok := counts[file]
+		if !ok {
+			continue
+		}
+		class := coberturaClass{Name: path.Base(file), Filename: getCoberturaFilename(exportFilename(profile, file), root)}
+		hit := 0
+		for _, line := range sortedLines(lines) {
+			class.Lines = append(class.Lines, coberturaLine{Number: line, Hits: lines[line]})
+			if lines[line] > 0 {
+				hit++
+			}
+		}
+		class.LineRate = getRate(hit, len(lines))
+
+		name := path.Dir(file)
+		i, ok := packages[name]
+		if !ok {
+			i = len(report.Packages)
+			packages[name] = i
+			report.Packages = append(report.Packages, coberturaPackage{Name: name})
+		}
+		report.Packages[i].Classes = append(report.Packages[i].Classes, class)
+		packageLines[name] = [2]int{packageLines[name][0] + hit, packageLines[name][1] + len(lines)}
+		report.LinesCovered += hit
+		report.LinesValid += len(lines)
+	}
+	for i := range report.Packages {
+		lines := packageLines[report.Packages[i].Name]
+		report.Packages[i].LineRate = getRate(lines[0], lines[1])
+	}
+	report.LineRate = getRate(report.LinesCovered, report.LinesValid)
+
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func getCoberturaFilename(filename, root string) string {
+	if rel, err := filepath.Rel(root, filename); err == nil && filepath.IsAbs(filename) && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(filename)
+}
+
+func getRate(hit, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(hit) / float64(total)
+}
@@ -0,0 +1,104 @@
+package autotest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var exportProfile = &Profile{
+	Mode: "set",
+	Blocks: []ProfileBlock{
+		{File: "example.com/m/pkg/a.go", StartLine: 3, EndLine: 4, NumStmt: 2, Count: 1},
+		{File: "example.com/m/pkg/a.go", StartLine: 4, EndLine: 5, NumStmt: 1, Count: 0},
+		{File: "example.com/m/pkg/a.go", StartLine: 7, EndLine: 7, NumStmt: 0, Count: 0},
+		{File: "example.com/m/b.go", StartLine: 2, EndLine: 2, NumStmt: 1, Count: 0},
+	},
+	Filenames: map[string]string{"example.com/m/pkg/a.go": "/src/m/pkg/a.go", "example.com/m/b.go": "/other/b.go"},
+}
+
+func TestWriteLCOV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeLCOV(&buf, exportProfile))
+	assert.Equal(t, `TN:
+SF:/other/b.go
+DA:2,0
+LF:1
+LH:0
+end_of_record
+TN:
+SF:/src/m/pkg/a.go
+DA:3,1
+DA:4,1
+DA:5,0
+LF:3
+LH:2
+end_of_record
+`, buf.String())
+}
+
+func TestWriteCobertura(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeCobertura(&buf, exportProfile, "/src/m", time.Unix(1, 0)))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">
+<coverage line-rate="0.5" branch-rate="0" lines-covered="2" lines-valid="4" branches-covered="0" branches-valid="0" complexity="0" version="" timestamp="1000">
+  <sources>
+    <source>/src/m</source>
+  </sources>
+  <packages>
+    <package name="example.com/m" line-rate="0" branch-rate="0" complexity="0">
+      <classes>
+        <class name="b.go" filename="/other/b.go" line-rate="0" branch-rate="0" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="2" hits="0"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+    <package name="example.com/m/pkg" line-rate="0.6666666666666666" branch-rate="0" complexity="0">
+      <classes>
+        <class name="a.go" filename="pkg/a.go" line-rate="0.6666666666666666" branch-rate="0" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="3" hits="1"></line>
+            <line number="4" hits="1"></line>
+            <line number="5" hits="0"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>
+`, buf.String())
+}
+
+func TestCoverageExporter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "coverage")
+	_, err := NewCoverageExporter(dir, ".", []string{"json"})
+	assert.Error(t, err)
+
+	e, err := NewCoverageExporter(dir, "/src/m", []string{ExportLCOV, ExportCobertura, ExportHTML})
+	require.NoError(t, err)
+	require.NoError(t, e.Update(&TestResult{Folder: "pkg", Profile: &Profile{Mode: "set", Blocks: exportProfile.Blocks[:2], Filenames: exportProfile.Filenames}}))
+	require.NoError(t, e.Update(&TestResult{Folder: ".", Profile: &Profile{Mode: "set", Blocks: exportProfile.Blocks[3:], Filenames: exportProfile.Filenames}}))
+	require.NoError(t, e.Update(&TestResult{Folder: "pkg"}), "a result without coverage keeps the previous coverage")
+
+	var expected bytes.Buffer
+	require.NoError(t, writeLCOV(&expected, exportProfile))
+	lcov, err := os.ReadFile(filepath.Join(dir, "lcov.info"))
+	require.NoError(t, err)
+	assert.Equal(t, expected.String(), string(lcov))
+	assert.FileExists(t, filepath.Join(dir, "cobertura.xml"))
+	assert.FileExists(t, filepath.Join(dir, "coverage.html"))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "temporary files are removed")
+}
@@ -0,0 +1,151 @@
+package autotest
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Run modes describe which tests of a package were run
+const (
+	RunModeFull     = ""         // every test in the package
+	RunModeFocus    = "focus"    // only the tests which failed on the previous run
+	RunModeConfirm  = "confirm"  // every test, after the focused tests passed
+	RunModeSelected = "selected" // only the tests covering the changed lines, see SelectTests
+	RunModeChanged  = "changed"  // only the tests changed in test files, see ChangedTests
+	RunModeFiltered = "filtered" // only the tests matching RunOptions.RunRegex
+)
+
+type focusState struct {
+	Tests    []string
+	Confirm  bool
+	Selected string // run mode of Tests which were selected rather than focused on because they failed
+}
+
+var focusedFolders = make(map[string]*focusState)
+var focusMutex sync.Mutex
+
+// UpdateFocus records the failing tests of a result so the next run of the folder can focus on them. It returns
+// true when the focused tests have all passed and the whole package should be run once to confirm
+func UpdateFocus(result *TestResult) bool {
+	focusMutex.Lock()
+	defer focusMutex.Unlock()
+	if result.Error != nil {
+		return false
+	}
+	if failing := getFailingLeafTests(result.Status); len(failing) != 0 {
+		focusedFolders[result.Folder] = &focusState{Tests: failing}
+		return false
+	}
+	if result.Mode == RunModeFiltered {
+		return false // the focused tests may not have matched the filter
+	}
+	if state, ok := focusedFolders[result.Folder]; ok && state.Selected != "" && state.Selected != result.Mode {
+		return false // tests were selected during the run
+	}
+	if result.Mode == RunModeFocus || result.Mode == RunModeSelected || result.Mode == RunModeChanged {
+		focusedFolders[result.Folder] = &focusState{Confirm: true}
+		return true
+	}
+	delete(focusedFolders, result.Folder)
+	return false
+}
+
+// SelectTests makes the next run of a folder run only the named tests, then the whole package once they pass to
+// confirm. A folder which is focused on its failing tests keeps that focus
+func SelectTests(folder string, tests []string, mode string) {
+	focusMutex.Lock()
+	defer focusMutex.Unlock()
+	if state, ok := focusedFolders[folder]; ok && !state.Confirm && state.Selected == "" {
+		return
+	}
+	focusedFolders[folder] = &focusState{Tests: tests, Selected: mode}
+}
+
+// FocusTests makes the next run of a folder run only the named tests, then the whole package once they pass. Unlike
+// the failing tests recorded by UpdateFocus, they are focused on even when RunOptions.Focus is off
+func FocusTests(folder string, tests []string) {
+	focusMutex.Lock()
+	defer focusMutex.Unlock()
+	focusedFolders[folder] = &focusState{Tests: tests, Selected: RunModeFocus}
+}
+
+// IsPartial returns true when only some of the package's tests were run
+func (r *TestResult) IsPartial() bool {
+	return r.Mode == RunModeFocus || r.Mode == RunModeSelected || r.Mode == RunModeChanged || r.Mode == RunModeFiltered
+}
+
+// getFocusRun returns the -run expression and run mode for the next run of a folder. Failing tests are only
+// focused on when focus is true
+func getFocusRun(folder string, focus bool) (string, string) {
+	focusMutex.Lock()
+	defer focusMutex.Unlock()
+	state, ok := focusedFolders[folder]
+	switch {
+	case !ok:
+		return "", RunModeFull
+	case state.Confirm:
+		return "", RunModeConfirm
+	case state.Selected != "":
+		return RunRegexForTests(state.Tests), state.Selected
+	case !focus:
+		return "", RunModeFull
+	}
+	return getFocusRegex(state.Tests), RunModeFocus
+}
+
+// getFailingLeafTests returns the failing tests, excluding parent tests which only failed because of a subtest
+func getFailingLeafTests(statuses []TestStatus) []string {
+	failing := []string{}
+	for _, status := range statuses {
+		if status.TestResult == "fail" && status.Test != "" {
+			failing = append(failing, status.Test)
+		}
+	}
+	leaves := []string{}
+	for _, test := range failing {
+		isParent := false
+		for _, other := range failing {
+			if strings.HasPrefix(other, test+"/") {
+				isParent = true
+				break
+			}
+		}
+		if !isParent {
+			leaves = append(leaves, test)
+		}
+	}
+	return leaves
+}
+
+// getFocusRegex builds a -run expression for test paths. go test matches each level of a subtest path separately,
+// so subtests can only be targeted when all paths are the same depth. Otherwise the top level tests are run
+func getFocusRegex(tests []string) string {
+	levels := [][]string{}
+	for _, test := range tests {
+		parts := strings.Split(test, "/")
+		if len(levels) != 0 && len(parts) != len(levels) {
+			return RunRegexForTests(tests)
+		}
+		if len(levels) == 0 {
+			levels = make([][]string, len(parts))
+		}
+		for i, part := range parts {
+			levels[i] = appendUnique(levels[i], regexp.QuoteMeta(part))
+		}
+	}
+	patterns := []string{}
+	for _, level := range levels {
+		patterns = append(patterns, "^("+strings.Join(level, "|")+")$")
+	}
+	return strings.Join(patterns, "/")
+}
+
+func appendUnique(items []string, item string) []string {
+	for _, existing := range items {
+		if existing == item {
+			return items
+		}
+	}
+	return append(items, item)
+}
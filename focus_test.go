@@ -0,0 +1,127 @@
+package autotest
+
+import (
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateFocus(t *testing.T) {
+	failing := &TestResult{Folder: "focus", Status: []TestStatus{
+		{Test: "TestA", TestResult: "fail"},
+		{Test: "TestA/sub_1", TestResult: "fail"},
+		{Test: "TestA/sub_2", TestResult: "pass"},
+		{Test: "TestB", TestResult: "pass"},
+		{TestResult: "fail"},
+	}}
+	assert.False(t, UpdateFocus(failing))
+	runRegex, mode := getFocusRun("focus", true)
+	assert.Equal(t, "^(TestA)$/^(sub_1)$", runRegex)
+	assert.Equal(t, RunModeFocus, mode)
+
+	assert.False(t, UpdateFocus(&TestResult{Folder: "focus", Error: &BuildError{}}))
+	_, mode = getFocusRun("focus", true)
+	assert.Equal(t, RunModeFocus, mode, "build errors keep the focus")
+
+	assert.True(t, UpdateFocus(&TestResult{Folder: "focus", Mode: RunModeFocus, Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}}))
+	runRegex, mode = getFocusRun("focus", true)
+	assert.Equal(t, "", runRegex)
+	assert.Equal(t, RunModeConfirm, mode)
+
+	assert.False(t, UpdateFocus(&TestResult{Folder: "focus", Mode: RunModeConfirm, Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}}))
+	_, mode = getFocusRun("focus", true)
+	assert.Equal(t, RunModeFull, mode)
+}
+
+func TestGetFocusRegex(t *testing.T) {
+	assert.Equal(t, "^(TestA|TestB)$", getFocusRegex([]string{"TestA", "TestB"}))
+	assert.Equal(t, "^(TestA|TestB)$/^(one|two)$", getFocusRegex([]string{"TestA/one", "TestB/two", "TestA/two"}))
+	assert.Equal(t, "^(TestA|TestB)$", getFocusRegex([]string{"TestA/one", "TestB"}))
+	assert.Equal(t, `^(TestA)$/^(a\+b)$`, getFocusRegex([]string{"TestA/a+b"}))
+}
+
+func TestRunTestsFocus(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	focusedFolders["runFocus"] = &focusState{Tests: []string{"TestA"}}
+	defer delete(focusedFolders, "runFocus")
+	result := RunTests("runFocus", RunOptions{TempDir: t.TempDir(), Focus: true})
+	assert.Equal(t, RunModeFocus, result.Mode)
+	assert.Nil(t, result.Coverage)
+}
+
+func TestSelectTests(t *testing.T) {
+	defer delete(focusedFolders, "select")
+	SelectTests("select", []string{"TestA", "TestB"}, RunModeSelected)
+	runRegex, mode := getFocusRun("select", false)
+	assert.Equal(t, "^(TestA|TestB)$", runRegex)
+	assert.Equal(t, RunModeSelected, mode)
+
+	assert.False(t, UpdateFocus(&TestResult{Folder: "select", Mode: RunModeFull}), "tests selected during a full run are kept")
+	_, mode = getFocusRun("select", false)
+	assert.Equal(t, RunModeSelected, mode)
+
+	assert.True(t, UpdateFocus(&TestResult{Folder: "select", Mode: RunModeSelected, Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}}))
+	_, mode = getFocusRun("select", false)
+	assert.Equal(t, RunModeConfirm, mode)
+
+	focusedFolders["select"] = &focusState{Tests: []string{"TestC"}}
+	SelectTests("select", []string{"TestA"}, RunModeSelected)
+	runRegex, mode = getFocusRun("select", true)
+	assert.Equal(t, "^(TestC)$", runRegex, "failing tests keep their focus")
+	assert.Equal(t, RunModeFocus, mode)
+	_, mode = getFocusRun("select", false)
+	assert.Equal(t, RunModeFull, mode, "failing tests are only focused on with -focus")
+	assert.True(t, (&TestResult{Mode: RunModeSelected}).IsPartial())
+	assert.False(t, (&TestResult{Mode: RunModeConfirm}).IsPartial())
+}
+
+func TestRunTestsSelected(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	SelectTests("runSelected", []string{"TestA"}, RunModeSelected)
+	defer delete(focusedFolders, "runSelected")
+	result := RunTests("runSelected", RunOptions{TempDir: t.TempDir()})
+	assert.Equal(t, RunModeSelected, result.Mode)
+	assert.Nil(t, result.Coverage)
+}
+
+func TestSelectChangedTests(t *testing.T) {
+	defer delete(focusedFolders, "changed")
+	SelectTests("changed", []string{"TestA"}, RunModeChanged)
+	runRegex, mode := getFocusRun("changed", true)
+	assert.Equal(t, "^(TestA)$", runRegex)
+	assert.Equal(t, RunModeChanged, mode)
+	assert.True(t, UpdateFocus(&TestResult{Folder: "changed", Mode: RunModeChanged, Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}}), "the whole package is run to confirm")
+	_, mode = getFocusRun("changed", true)
+	assert.Equal(t, RunModeConfirm, mode)
+}
+
+func TestFocusTests(t *testing.T) {
+	defer delete(focusedFolders, "focusTests")
+	FocusTests("focusTests", []string{"TestA/sub"})
+	runRegex, mode := getFocusRun("focusTests", false)
+	assert.Equal(t, "^(TestA)$", runRegex, "focused on even without -focus")
+	assert.Equal(t, RunModeFocus, mode)
+
+	assert.False(t, UpdateFocus(&TestResult{Folder: "focusTests", Mode: RunModeFiltered}), "filtered runs keep the focus")
+	_, mode = getFocusRun("focusTests", false)
+	assert.Equal(t, RunModeFocus, mode)
+
+	assert.True(t, UpdateFocus(&TestResult{Folder: "focusTests", Mode: RunModeFocus, Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}}))
+	_, mode = getFocusRun("focusTests", false)
+	assert.Equal(t, RunModeConfirm, mode)
+}
+
+func TestRunTestsFiltered(t *testing.T) {
+	previous := exec
+	defer func() { exec = previous }()
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	result := RunTests("runFiltered", RunOptions{TempDir: t.TempDir(), RunRegex: "TestA"})
+	assert.Equal(t, RunModeFiltered, result.Mode)
+	assert.True(t, result.IsPartial())
+	assert.Nil(t, result.Coverage)
+}
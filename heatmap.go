@@ -0,0 +1,208 @@
+package autotest
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cover modes passed to go test -covermode. Only count and atomic record how often each block ran
+const (
+	CoverModeSet    = "set"
+	CoverModeCount  = "count"
+	CoverModeAtomic = "atomic"
+)
+
+// DefaultHottestLines is the number of most executed lines listed by a ConsoleReporter unless changed
+const DefaultHottestLines = 5
+
+// HotLine is a line of code along with the number of times it ran
+type HotLine struct {
+	File   string // import path of the file
+	Line   int
+	Count  int
+	Source string // the line of code, when the file could be read
+}
+
+// hasCounts returns true when the profile records execution counts rather than just whether blocks ran
+func (p *Profile) hasCounts() bool {
+	return p.Mode == CoverModeCount || p.Mode == CoverModeAtomic
+}
+
+// getHottestLines returns the n lines which ran most often, most often first. Lines which didn't run and lines with
+// just a closing brace are left out
+func getHottestLines(profile *Profile, n int) []HotLine {
+	lines := []HotLine{}
+	for file, counts := range getLineCounts(profile) {
+		source := readSourceLines(exportFilename(profile, file))
+		for line, count := range counts {
+			hot := HotLine{File: file, Line: line, Count: count}
+			if line <= len(source) {
+				hot.Source = strings.TrimSpace(source[line-1])
+			}
+			if count > 0 && hot.Source != "}" {
+				lines = append(lines, hot)
+			}
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Count != lines[j].Count {
+			return lines[i].Count > lines[j].Count
+		} else if lines[i].File != lines[j].File {
+			return lines[i].File < lines[j].File
+		}
+		return lines[i].Line < lines[j].Line
+	})
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines
+}
+
+// readSourceLines returns the lines of a file, or nil when it can't be read
+func readSourceLines(filename string) []string {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+type heatmapReport struct {
+	Generated string
+	Mode      string
+	Percent   string
+	Files     []heatmapFile
+}
+
+type heatmapFile struct {
+	ID       string
+	Name     string
+	Percent  string
+	MaxCount int
+	Lines    []heatmapLine
+}
+
+type heatmapLine struct {
+	Number int
+	Source string
+	Class  string       // covered, uncovered or empty when the line has no statements
+	Style  template.CSS // background color scaled by the count in count and atomic mode
+	Title  string
+}
+
+// writeHTML writes a report showing the source of every file in the profile. In set mode, lines are marked as
+// covered or not. In count and atomic mode, covered lines are shaded from yellow to red by how often they ran
+func writeHTML(w io.Writer, profile *Profile, root string, timestamp time.Time) error {
+	mode := profile.Mode
+	if mode == "" {
+		mode = CoverModeSet
+	}
+	counts := getLineCounts(profile)
+	report := heatmapReport{Generated: timestamp.Format(time.RFC1123), Mode: mode, Percent: getPercentText(float64(getStatementCoverage(profile.Blocks)))}
+	for i, file := range profile.Files() {
+		lines, ok := counts[file]
+		if !ok {
+			continue
+		}
+		filename := exportFilename(profile, file)
+		heatmap := heatmapFile{ID: fmt.Sprintf("file%d", i), Name: getCoberturaFilename(filename, root)}
+		for _, count := range lines {
+			if count > heatmap.MaxCount {
+				heatmap.MaxCount = count
+			}
+		}
+		var blocks []ProfileBlock
+		for _, block := range profile.Blocks {
+			if block.File == file {
+				blocks = append(blocks, block)
+			}
+		}
+		heatmap.Percent = getPercentText(float64(getStatementCoverage(blocks)))
+
+		source := readSourceLines(filename)
+		if source == nil { // show the lines with statements without their code
+			for _, line := range sortedLines(lines) {
+				for len(source) < line {
+					source = append(source, "")
+				}
+			}
+		}
+		for i, text := range source {
+			line := heatmapLine{Number: i + 1, Source: text}
+			if count, ok := lines[i+1]; ok {
+				line.Class, line.Title = "uncovered", "not run"
+				if count > 0 {
+					line.Class, line.Title = "covered", "run"
+				}
+				if profile.hasCounts() && count > 0 {
+					line.Style = template.CSS("background-color: " + getHeatColor(count, heatmap.MaxCount))
+					line.Title = fmt.Sprintf("run %d times", count)
+					if count == 1 {
+						line.Title = "run once"
+					}
+				}
+			}
+			heatmap.Lines = append(heatmap.Lines, line)
+		}
+		report.Files = append(report.Files, heatmap)
+	}
+	return heatmapTemplate.Execute(w, report)
+}
+
+// getHeatColor shades counts on a log scale from pale yellow for lines which ran once to red for the most run line
+func getHeatColor(count, maxCount int) string {
+	heat := 1.0
+	if maxCount > 1 {
+		heat = math.Log(float64(count)) / math.Log(float64(maxCount))
+	}
+	return fmt.Sprintf("hsl(%d, 100%%, %d%%)", int(55-55*heat), int(85-30*heat))
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; margin: 0; }
+header { background: #333; color: #eee; padding: 8px 16px; }
+select { margin-left: 16px; }
+table { border-collapse: collapse; font-family: monospace; white-space: pre; width: 100%; }
+td.number { color: #999; text-align: right; padding-right: 12px; user-select: none; width: 1%; }
+tr.covered td.source { background-color: #c8f0c8; }
+tr.uncovered td.source { background-color: #f8c8c8; }
+.file { display: none; }
+.file:target, .file.first { display: block; }
+</style>
+</head>
+<body>
+<header>
+{{.Percent}} of statements covered ({{.Mode}} mode), generated {{.Generated}}
+<select onchange="location.hash = this.value">
+{{- range .Files}}
+<option value="{{.ID}}">{{.Name}} ({{.Percent}}{{if gt .MaxCount 1}}, max {{.MaxCount}} runs{{end}})</option>
+{{- end}}
+</select>
+</header>
+{{- range $i, $file := .Files}}
+<div class="file{{if eq $i 0}} first{{end}}" id="{{$file.ID}}">
+<table>
+{{- range $file.Lines}}
+<tr{{if .Class}} class="{{.Class}}" title="{{.Title}}"{{end}}><td class="number">{{.Number}}</td><td class="source"{{if .Style}} style="{{.Style}}"{{end}}>{{.Source}}</td></tr>
+{{- end}}
+</table>
+</div>
+{{- end}}
+<script>
+if (location.hash) { document.querySelector(".first").classList.remove("first") }
+window.addEventListener("hashchange", function () { var first = document.querySelector(".first"); if (first) { first.classList.remove("first") } })
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,65 @@
+package autotest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countProfile(t *testing.T) *Profile {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	require.NoError(t, os.WriteFile(filename, []byte("package a\n\nfunc Sum(n int) (sum int) {\n\tfor i := 0; i < n; i++ {\n\t\tsum += i\n\t}\n\treturn sum\n}\n\nfunc Unused() {\n\tprintln(\"<unused>\")\n}\n"), 0644))
+	return &Profile{
+		Mode: CoverModeCount,
+		Blocks: []ProfileBlock{
+			{File: "example.com/m/a.go", StartLine: 3, StartCol: 28, EndLine: 4, EndCol: 25, NumStmt: 2, Count: 1},
+			{File: "example.com/m/a.go", StartLine: 4, StartCol: 25, EndLine: 6, EndCol: 3, NumStmt: 1, Count: 100},
+			{File: "example.com/m/a.go", StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 12, NumStmt: 1, Count: 1},
+			{File: "example.com/m/a.go", StartLine: 10, StartCol: 15, EndLine: 12, EndCol: 2, NumStmt: 1, Count: 0},
+		},
+		Filenames: map[string]string{"example.com/m/a.go": filename},
+	}
+}
+
+func TestGetHottestLines(t *testing.T) {
+	profile := countProfile(t)
+	assert.Equal(t, []HotLine{
+		{File: "example.com/m/a.go", Line: 4, Count: 100, Source: "for i := 0; i < n; i++ {"},
+		{File: "example.com/m/a.go", Line: 5, Count: 100, Source: "sum += i"},
+		{File: "example.com/m/a.go", Line: 3, Count: 1, Source: "func Sum(n int) (sum int) {"},
+	}, getHottestLines(profile, 3))
+	assert.Len(t, getHottestLines(profile, 10), 4, "lines which didn't run or only close a block are left out")
+
+	profile.Filenames = nil
+	assert.Equal(t, "", getHottestLines(profile, 1)[0].Source, "the source is left out when the file can't be read")
+}
+
+func TestWriteHTML(t *testing.T) {
+	profile := countProfile(t)
+	var buf bytes.Buffer
+	require.NoError(t, writeHTML(&buf, profile, filepath.Dir(profile.Filenames["example.com/m/a.go"]), time.Unix(0, 0).UTC()))
+	html := buf.String()
+	assert.Contains(t, html, "80.0% of statements covered (count mode)")
+	assert.Contains(t, html, `<option value="file0">a.go (80.0%, max 100 runs)</option>`)
+	assert.Contains(t, html, `<tr class="covered" title="run 100 times"><td class="number">5</td><td class="source" style="background-color: hsl(0, 100%, 55%)">		sum &#43;= i</td></tr>`)
+	assert.Contains(t, html, `<tr class="covered" title="run once"><td class="number">7</td><td class="source" style="background-color: hsl(55, 100%, 85%)">	return sum</td></tr>`)
+	assert.Contains(t, html, `<tr class="uncovered" title="not run"><td class="number">11</td><td class="source">	println(&#34;&lt;unused&gt;&#34;)</td></tr>`)
+	assert.Contains(t, html, `<tr><td class="number">9</td><td class="source"></td></tr>`)
+
+	profile.Mode = CoverModeSet
+	buf.Reset()
+	require.NoError(t, writeHTML(&buf, profile, "/", time.Unix(0, 0)))
+	assert.Contains(t, buf.String(), `<tr class="covered" title="run"><td class="number">5</td><td class="source">`, "set mode has no heatmap")
+}
+
+func TestGetHeatColor(t *testing.T) {
+	assert.Equal(t, "hsl(0, 100%, 55%)", getHeatColor(1, 1))
+	assert.Equal(t, "hsl(55, 100%, 85%)", getHeatColor(1, 1000))
+	assert.Equal(t, "hsl(27, 100%, 70%)", getHeatColor(31, 1000))
+	assert.Equal(t, "hsl(0, 100%, 55%)", getHeatColor(1000, 1000))
+}
@@ -0,0 +1,257 @@
+package autotest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profile of the last integration run. It is merged into the coverage of every later test run, except for the
+// files which changed since the binaries were built
+var integrationProfile *Profile
+var integrationHashes map[string]string // hash of each covered file when the binaries were built
+var integrationMutex sync.Mutex
+
+// IntegrationCoverage measures the coverage of running binaries end to end. The main packages are built with
+// go build -cover into a bin folder and the command is run with that folder first in PATH and GOCOVERDIR set.
+// The coverage data written by the binaries is converted with go tool covdata and merged with the unit test
+// coverage of each package
+type IntegrationCoverage struct {
+	Module   *Module  // packages are built and the command run from the module folder
+	Packages []string // main packages to build, e.g. ./cmd/...
+	Command  []string // command run with the binaries, e.g. ./scripts/acceptance.sh
+	Mode     string   // passed to go build -covermode when set. Should match RunOptions.CoverMode
+	BinDir   string   // the built binaries. Also set in the command's environment as AUTOTEST_BIN
+	CoverDir string   // covdata written by the binaries
+	Profile  string   // the converted coverage profile
+}
+
+// IntegrationResult contains the outcome of an integration run. The coverage of a failing command is still kept
+type IntegrationResult struct {
+	ExitCode int
+	Output   string
+	Profile  *Profile
+	Error    error // the binaries didn't build or no coverage could be read
+}
+
+// NewIntegrationCoverage returns an integration run for the packages and command, keeping its files in tempDir
+func NewIntegrationCoverage(module *Module, packages []string, command, tempDir string) (*IntegrationCoverage, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing integration command")
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("missing packages to build for the integration command")
+	}
+	dir, err := filepath.Abs(filepath.Join(tempDir, "integration"))
+	if err != nil {
+		return nil, err
+	}
+	return &IntegrationCoverage{
+		Module:   module,
+		Packages: packages,
+		Command:  args,
+		BinDir:   filepath.Join(dir, "bin"),
+		CoverDir: filepath.Join(dir, "covdata"),
+		Profile:  filepath.Join(dir, "integration.cover.out"),
+	}, nil
+}
+
+// splitCommand splits a command line into its arguments like a shell would, without expanding anything. Single
+// quotes keep everything up to the closing quote, double quotes allow \" and \\ escapes, and a backslash outside
+// of quotes keeps the next character, e.g. './scripts/run acceptance.sh' or ./scripts/run\ acceptance.sh
+func splitCommand(command string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		switch c := command[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' in command %s", command)
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i, inArg = i+1+end, true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && (command[i+1] == '"' || command[i+1] == '\\') {
+					i++
+				}
+				arg.WriteByte(command[i])
+			}
+			if i == len(command) {
+				return nil, fmt.Errorf("unterminated \" in command %s", command)
+			}
+			inArg = true
+		case c == '\\' && i+1 < len(command):
+			i++
+			arg.WriteByte(command[i])
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// Run builds the binaries, runs the command and records the coverage so later test runs include it
+func (c *IntegrationCoverage) Run() *IntegrationResult {
+	dir := "."
+	if c.Module != nil {
+		dir = c.Module.Dir
+	}
+	for _, folder := range []string{c.BinDir, c.CoverDir} { // coverage of earlier runs would be merged in otherwise
+		os.RemoveAll(folder)
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			return &IntegrationResult{Error: err}
+		}
+	}
+	args := []string{"build", "-cover", "-coverpkg", getCoverPkgArg(c.Module), "-o", c.BinDir + string(filepath.Separator)}
+	if c.Mode != "" {
+		args = append(args, "-covermode", c.Mode)
+	}
+	args = append(args, c.Packages...)
+	built := time.Now()
+	if out, exitCode := runGoTool(dir, args); exitCode != 0 {
+		return &IntegrationResult{Error: &BuildError{Output: strings.TrimSpace(string(out))}}
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.SetDir(dir)
+	cmd.SetEnv(append(os.Environ(), "GOCOVERDIR="+c.CoverDir, "AUTOTEST_BIN="+c.BinDir, "PATH="+c.BinDir+string(filepath.ListSeparator)+os.Getenv("PATH")))
+	cmd.SetStdout(&output)
+	cmd.SetStderr(&output)
+	result := &IntegrationResult{}
+	if err := cmd.Run(); err != nil {
+		result.ExitCode = -1
+		if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		output.WriteString(err.Error())
+	}
+	result.Output = strings.TrimSpace(output.String())
+
+	if out, exitCode := runGoTool(dir, []string{"tool", "covdata", "textfmt", "-i", c.CoverDir, "-o", c.Profile}); exitCode != 0 {
+		result.Error = fmt.Errorf("unable to read integration coverage: %s", strings.TrimSpace(string(out)))
+		return result
+	}
+	profile, err := readProfile(c.Profile)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	profile.resolveFilenames(c.Module, dir)
+	result.Profile = profile
+	setIntegrationProfile(profile, getBuiltHashes(profile, built))
+	return result
+}
+
+// getBuiltHashes hashes each file of a profile. Files modified after the build started are left out as the
+// binaries may not match them
+func getBuiltHashes(profile *Profile, built time.Time) map[string]string {
+	hashes := make(map[string]string)
+	for _, filename := range profile.Filenames {
+		if stat, err := os.Stat(filename); err != nil || stat.ModTime().After(built) {
+			continue
+		}
+		if hash, err := hashFile(filename); err == nil {
+			hashes[filename] = hash
+		}
+	}
+	return hashes
+}
+
+func hashFile(filename string) (string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func setIntegrationProfile(profile *Profile, hashes map[string]string) {
+	integrationMutex.Lock()
+	integrationProfile, integrationHashes = profile, hashes
+	integrationMutex.Unlock()
+}
+
+// getIntegrationProfile returns the integration coverage of the files given by import path. Files which changed
+// since the binaries were built are left out as their blocks no longer match the lines of the file. Nil is
+// returned when there's been no integration run
+func getIntegrationProfile(files map[string]string) *Profile {
+	integrationMutex.Lock()
+	profile, hashes := integrationProfile, integrationHashes
+	integrationMutex.Unlock()
+	if profile == nil {
+		return nil
+	}
+	unchanged := make(map[string]string)
+	for file, filename := range files {
+		if hash, err := hashFile(profile.Filenames[file]); err == nil && hash == hashes[profile.Filenames[file]] {
+			unchanged[file] = filename
+		}
+	}
+	return profile.filesProfile(unchanged)
+}
+
+// filesProfile returns the part of a profile covering the files given by import path
+func (p *Profile) filesProfile(files map[string]string) *Profile {
+	filtered := &Profile{Mode: p.Mode, Filenames: make(map[string]string)}
+	for _, block := range p.Blocks {
+		if _, ok := files[block.File]; ok {
+			filtered.Blocks = append(filtered.Blocks, block)
+			filtered.Filenames[block.File] = p.Filenames[block.File]
+		}
+	}
+	return filtered
+}
+
+// getIntegrationCoverage sets the coverage of each function in own, the profile of the tested package, by its
+// tests together with the integration command
+func getIntegrationCoverage(coverage []FunctionCoverage, own, integration *Profile, sources *sourceFiles) []FunctionCoverage {
+	merged := mergeProfiles(own, integration.filesProfile(own.Filenames))
+	blocksByFile := make(map[string][]ProfileBlock)
+	for _, block := range merged.Blocks {
+		blocksByFile[block.File] = append(blocksByFile[block.File], block)
+	}
+	extents := make(map[string][]functionExtent)
+	for i, item := range coverage {
+		switch filename, ok := own.Filenames[item.Path]; {
+		case item.Filename == "total":
+			coverage[i].IntegrationPercent = getStatementCoverage(merged.Blocks)
+		case ok:
+			if _, parsed := extents[item.Path]; !parsed {
+				extents[item.Path] = sources.functionExtents(filename, func(*ast.FuncDecl) bool { return true })
+			}
+			coverage[i].IntegrationPercent = getFunctionCoverage(blocksByFile[item.Path], extents[item.Path], item)
+		default:
+			coverage[i].IntegrationPercent = item.CoveragePercent
+		}
+		coverage[i].Integration = true
+	}
+	return coverage
+}
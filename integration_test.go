@@ -0,0 +1,142 @@
+package autotest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var integrationModule = map[string]string{
+	"go.mod": "module example.com/m\n\ngo 1.20\n",
+	"calc/calc.go": `package calc
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Sub(a, b int) int {
+	return a - b
+}
+`,
+	"calc/calc_test.go": `package calc
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Fail()
+	}
+}
+`,
+	"cmd/calc/main.go": `package main
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/m/calc"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "sub" {
+		fmt.Println(calc.Sub(3, 1))
+	}
+}
+`,
+	"acceptance.sh": "#!/bin/sh\ncalc sub\n",
+}
+
+func TestIntegrationCoverage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the acceptance script needs a shell")
+	}
+	exec = execfactory.NewOSCreator()
+	defer setIntegrationProfile(nil, nil)
+	root := t.TempDir()
+	writeFiles(t, root, integrationModule)
+	require.NoError(t, os.Chmod(filepath.Join(root, "acceptance.sh"), 0755))
+	module := &Module{Path: "example.com/m", Dir: root}
+	tempDir := t.TempDir()
+
+	integration, err := NewIntegrationCoverage(module, []string{"./cmd/calc"}, "./acceptance.sh", tempDir)
+	require.NoError(t, err)
+	result := integration.Run()
+	require.NoError(t, result.Error)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "2", result.Output)
+	assert.Equal(t, filepath.Join(root, "calc", "calc.go"), result.Profile.Filenames["example.com/m/calc/calc.go"])
+
+	test := RunTests(filepath.Join(root, "calc"), RunOptions{TempDir: tempDir, Module: module})
+	require.NoError(t, test.Error)
+	require.Len(t, test.Coverage, 3)
+	assert.Equal(t, FunctionCoverage{Path: "example.com/m/calc/calc.go", Filename: "calc.go", Function: "Sub", LineNumber: 7,
+		CoveragePercent: 0, IntegrationPercent: 100, Integration: true, Complexity: 1, CRAP: 2}, test.Coverage[1])
+	assert.Equal(t, float32(100), test.Coverage[2].IntegrationPercent)
+	assert.Equal(t, float32(100), getStatementCoverage(test.Profile.Blocks), "the integration coverage is merged into the profile")
+	assert.Len(t, test.Profile.Files(), 1, "only the package's own files are merged")
+
+	writeFiles(t, root, map[string]string{"calc/calc.go": "package calc\n\n// Add adds\n" + integrationModule["calc/calc.go"][len("package calc\n\n"):]})
+	test = RunTests(filepath.Join(root, "calc"), RunOptions{TempDir: tempDir, Module: module})
+	require.NoError(t, test.Error)
+	assert.Equal(t, float32(0), test.Coverage[1].IntegrationPercent, "files changed since the build aren't merged")
+}
+
+func TestIntegrationCoverageFailures(t *testing.T) {
+	_, err := NewIntegrationCoverage(nil, []string{"./..."}, " ", t.TempDir())
+	assert.Error(t, err)
+	_, err = NewIntegrationCoverage(nil, nil, "./run.sh", t.TempDir())
+	assert.Error(t, err)
+
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{{SimpleOutputOut: []byte("main.go:1:1: syntax error"), SimpleOutputExitCode: 1}})
+	integration, err := NewIntegrationCoverage(nil, []string{"./..."}, "./run.sh", t.TempDir())
+	require.NoError(t, err)
+	result := integration.Run()
+	assert.IsType(t, &BuildError{}, result.Error)
+	assert.Nil(t, getIntegrationProfile(nil))
+}
+
+func TestGetIntegrationCoverage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.go")
+	require.NoError(t, os.WriteFile(filename, []byte(integrationModule["calc/calc.go"]), 0644))
+	files := map[string]string{"m/a.go": filename}
+	own := &Profile{Mode: "set", Filenames: files, Blocks: []ProfileBlock{
+		{File: "m/a.go", StartLine: 3, StartCol: 24, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 1},
+		{File: "m/a.go", StartLine: 7, StartCol: 24, EndLine: 9, EndCol: 2, NumStmt: 1, Count: 0},
+	}}
+	integration := &Profile{Mode: "set", Filenames: map[string]string{"m/a.go": filename, "m/b.go": "b.go"}, Blocks: []ProfileBlock{
+		{File: "m/a.go", StartLine: 7, StartCol: 24, EndLine: 9, EndCol: 2, NumStmt: 1, Count: 1},
+		{File: "m/b.go", StartLine: 1, StartCol: 1, EndLine: 2, EndCol: 2, NumStmt: 1, Count: 0},
+	}}
+	coverage := getIntegrationCoverage([]FunctionCoverage{
+		{Path: "m/a.go", Filename: "a.go", Function: "Add", LineNumber: 3, CoveragePercent: 100},
+		{Path: "m/a.go", Filename: "a.go", Function: "Sub", LineNumber: 7, CoveragePercent: 0},
+		{Filename: "total", Function: "(statements)", CoveragePercent: 50},
+	}, own, integration, newSourceFiles())
+	assert.Equal(t, []float32{100, 100, 100}, []float32{coverage[0].IntegrationPercent, coverage[1].IntegrationPercent, coverage[2].IntegrationPercent})
+	assert.True(t, coverage[2].Integration)
+}
+
+func TestSplitCommand(t *testing.T) {
+	for command, expected := range map[string][]string{
+		"./acceptance.sh -v":              {"./acceptance.sh", "-v"},
+		"  ./run   a\tb ":                 {"./run", "a", "b"},
+		"'./scripts/run acceptance.sh' x": {"./scripts/run acceptance.sh", "x"},
+		`"./scripts/run acceptance.sh"`:   {"./scripts/run acceptance.sh"},
+		`./scripts/run\ acceptance.sh`:    {"./scripts/run acceptance.sh"},
+		`go test -run "Test\"A\"" ''`:     {"go", "test", "-run", `Test"A"`, ""},
+		`a"b c"'d'`:                       {"ab cd"},
+	} {
+		args, err := splitCommand(command)
+		require.NoError(t, err, command)
+		assert.Equal(t, expected, args, command)
+	}
+	_, err := splitCommand("run 'acceptance")
+	assert.Error(t, err)
+	_, err = splitCommand(`run "acceptance`)
+	assert.Error(t, err)
+}
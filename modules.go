@@ -0,0 +1,213 @@
+package autotest
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Module is a Go module found in the watched folders
+type Module struct {
+	Path string // module path from go.mod
+	Dir  string // absolute path of the folder containing go.mod
+}
+
+// Workspace contains the modules being watched
+type Workspace struct {
+	Modules []*Module
+}
+
+// ModuleFolders contains the watched folders owned by a module
+type ModuleFolders struct {
+	Module  *Module
+	Folders []string
+}
+
+// LoadWorkspace finds the modules under root. When a go.work file is found in root or one of its parents, the
+// modules it uses are loaded. Otherwise root is searched for go.mod files, including nested modules
+func LoadWorkspace(root string) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := findWorkspaceModules(root)
+	if err != nil {
+		return nil, err
+	}
+	if dirs == nil {
+		if dirs, err = findModuleDirs(root); err != nil {
+			return nil, err
+		}
+	}
+	w := &Workspace{}
+	for _, dir := range dirs {
+		modulePath, err := readModulePath(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+		w.Modules = append(w.Modules, &Module{Path: modulePath, Dir: dir})
+	}
+	sort.Slice(w.Modules, func(i, j int) bool { return w.Modules[i].Dir < w.Modules[j].Dir })
+	return w, nil
+}
+
+// ModuleFor returns the innermost module containing folder, or nil if the folder is not part of any module
+func (w *Workspace) ModuleFor(folder string) *Module {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil
+	}
+	var owner *Module
+	for _, module := range w.Modules {
+		if isSubfolder(module.Dir, folder) && (owner == nil || len(module.Dir) > len(owner.Dir)) {
+			owner = module
+		}
+	}
+	return owner
+}
+
+// GroupFolders groups folders by their owning module in module order. Folders outside of any module are
+// returned last with a nil Module
+func (w *Workspace) GroupFolders(folders []string) []ModuleFolders {
+	grouped := make(map[*Module][]string)
+	for _, folder := range folders {
+		module := w.ModuleFor(folder)
+		grouped[module] = append(grouped[module], folder)
+	}
+	groups := []ModuleFolders{}
+	for _, module := range w.Modules {
+		if len(grouped[module]) != 0 {
+			groups = append(groups, ModuleFolders{Module: module, Folders: grouped[module]})
+		}
+	}
+	if len(grouped[nil]) != 0 {
+		groups = append(groups, ModuleFolders{Folders: grouped[nil]})
+	}
+	return groups
+}
+
+// PackageArg returns the relative package pattern used to test folder from the module root
+func (m *Module) PackageArg(folder string) string {
+	folder, _ = filepath.Abs(folder)
+	rel, err := filepath.Rel(m.Dir, folder)
+	if err != nil || rel == "." {
+		return "."
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+func isSubfolder(parent, folder string) bool {
+	return folder == parent || strings.HasPrefix(folder, parent+string(filepath.Separator))
+}
+
+// findWorkspaceModules returns the module folders listed in the nearest go.work file, or nil if there isn't one
+func findWorkspaceModules(root string) ([]string, error) {
+	for dir := root; ; dir = filepath.Dir(dir) {
+		workFile := filepath.Join(dir, "go.work")
+		if _, err := os.Stat(workFile); err == nil {
+			return readWorkspaceUses(workFile)
+		}
+		if filepath.Dir(dir) == dir {
+			return nil, nil
+		}
+	}
+}
+
+func readWorkspaceUses(workFile string) ([]string, error) {
+	f, err := os.Open(workFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dirs := []string{}
+	inUseBlock := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := stripComment(scanner.Text())
+		switch {
+		case inUseBlock && line == ")":
+			inUseBlock = false
+		case inUseBlock && line != "":
+			dirs = append(dirs, getUseDir(workFile, line))
+		case line == "use" || hasAnyPrefix(line, []string{"use ", "use\t", "use("}):
+			switch use := strings.TrimSpace(line[len("use"):]); {
+			case use == "(":
+				inUseBlock = true
+			case strings.HasPrefix(use, "(") && strings.HasSuffix(use, ")"): // use (./a) on one line
+				if use = strings.TrimSpace(use[1 : len(use)-1]); use != "" {
+					dirs = append(dirs, getUseDir(workFile, use))
+				}
+			case use != "":
+				dirs = append(dirs, getUseDir(workFile, use))
+			}
+		}
+	}
+	return dirs, scanner.Err()
+}
+
+// getUseDir returns the folder of a use directive. Relative folders are relative to the go.work file
+func getUseDir(workFile, use string) string {
+	dir := filepath.FromSlash(unquote(use))
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(filepath.Dir(workFile), dir)
+}
+
+func findModuleDirs(root string) ([]string, error) {
+	dirs := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != root && isIgnoredFolder(d.Name()) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == "go.mod" {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// isIgnoredFolder matches the folders ignored by the go command when matching packages
+func isIgnoredFolder(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" || name == "node_modules"
+}
+
+func readModulePath(modFile string) (string, error) {
+	f, err := os.Open(modFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := stripComment(scanner.Text())
+		if strings.HasPrefix(line, "module ") {
+			return unquote(strings.TrimSpace(line[len("module "):])), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s: missing module path", modFile)
+}
+
+func stripComment(line string) string {
+	if i := strings.Index(line, "//"); i != -1 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+func unquote(s string) string {
+	return strings.Trim(s, "\"`")
+}
@@ -0,0 +1,88 @@
+package autotest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	}
+}
+
+func TestLoadWorkspaceNestedModules(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":               "module example.com/root\n\ngo 1.17\n",
+		"api/api.go":           "package api",
+		"tools/go.mod":         "// tools module\nmodule \"example.com/root/tools\"\n",
+		"tools/gen/gen.go":     "package gen",
+		"testdata/go.mod":      "module ignored\n",
+		".hidden/go.mod":       "module ignored\n",
+		"other/nomod/other.go": "package nomod",
+	})
+	w, err := LoadWorkspace(root)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(w.Modules))
+	assert.Equal(t, &Module{Path: "example.com/root", Dir: root}, w.Modules[0])
+	assert.Equal(t, &Module{Path: "example.com/root/tools", Dir: filepath.Join(root, "tools")}, w.Modules[1])
+
+	assert.Equal(t, w.Modules[1], w.ModuleFor(filepath.Join(root, "tools", "gen")))
+	assert.Equal(t, w.Modules[0], w.ModuleFor(filepath.Join(root, "api")))
+	assert.Nil(t, w.ModuleFor(os.TempDir()))
+
+	groups := w.GroupFolders([]string{filepath.Join(root, "tools", "gen"), filepath.Join(root, "api"), os.TempDir(), root})
+	require.Equal(t, 3, len(groups))
+	assert.Equal(t, []string{filepath.Join(root, "api"), root}, groups[0].Folders)
+	assert.Equal(t, []string{filepath.Join(root, "tools", "gen")}, groups[1].Folders)
+	assert.Nil(t, groups[2].Module)
+}
+
+func TestLoadWorkspaceGoWork(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.work":          "go 1.18\n\nuse (\n\t./a // first\n\t\"./b\"\n)\nuse ./c\n",
+		"a/go.mod":         "module example.com/a\n",
+		"b/go.mod":         "module example.com/b\n",
+		"c/go.mod":         "module example.com/c\n",
+		"unused/go.mod":    "module example.com/unused\n",
+		"a/internal/x.go":  "package internal",
+		"b/cmd/main/b.go":  "package main",
+		"unused/unused.go": "package unused",
+	})
+	w, err := LoadWorkspace(filepath.Join(root, "a"))
+	require.NoError(t, err)
+	paths := []string{}
+	for _, module := range w.Modules {
+		paths = append(paths, module.Path)
+	}
+	assert.Equal(t, []string{"example.com/a", "example.com/b", "example.com/c"}, paths)
+	assert.Equal(t, "./cmd/main", w.ModuleFor(filepath.Join(root, "b", "cmd", "main")).PackageArg(filepath.Join(root, "b", "cmd", "main")))
+	assert.Equal(t, ".", w.Modules[0].PackageArg(filepath.Join(root, "a")))
+}
+
+func TestReadWorkspaceUses(t *testing.T) {
+	root := t.TempDir()
+	abs := filepath.Join(t.TempDir(), "elsewhere")
+	writeFiles(t, root, map[string]string{"go.work": "go 1.18\n\nuse(\n\t./a\n)\nuse " + abs + "\nuse (./b)\nuses ./ignored\n"})
+	dirs, err := readWorkspaceUses(filepath.Join(root, "go.work"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "a"), abs, filepath.Join(root, "b")}, dirs)
+}
+
+func TestReadModulePath(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"mod/go.mod": "go 1.17\n"})
+	_, err := readModulePath(filepath.Join(root, "mod", "go.mod"))
+	assert.EqualError(t, err, filepath.Join(root, "mod", "go.mod")+": missing module path")
+
+	_, err = readModulePath(filepath.Join(root, "missing", "go.mod"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,261 @@
+package autotest
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PatchCoverage is the coverage of the lines changed since the patch base
+type PatchCoverage struct {
+	Base      string
+	Files     []PatchFile
+	Covered   int     // changed lines run by the tests
+	Total     int     // changed lines containing statements. Blank lines, comments and closing braces aren't counted
+	Threshold float32 // minimum percentage of changed lines which must be covered. 0 disables
+}
+
+// PatchFile is the coverage of the changed lines of a single file
+type PatchFile struct {
+	File      string // import path of the file
+	Covered   int
+	Total     int
+	Uncovered []int // line numbers of changed statements which weren't run
+}
+
+// Percent returns the percentage of changed lines which are covered
+func (p *PatchCoverage) Percent() float32 {
+	if p.Total == 0 {
+		return 100
+	}
+	percent, _ := strconv.ParseFloat(formatFloat(100*float64(p.Covered)/float64(p.Total), 1), 32)
+	return float32(percent)
+}
+
+// BelowThreshold returns true when a threshold is set and too few of the changed lines are covered
+func (p *PatchCoverage) BelowThreshold() bool {
+	return p.Threshold > 0 && p.Total > 0 && p.Percent() < p.Threshold
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// getChangedLines returns the lines of the files in folder which changed since base, keyed by absolute file
+// name. Untracked go files are new, so all of their lines have changed
+func getChangedLines(folder, base string) (map[string][]int, error) {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := runGit(abs, "diff", "-U0", "--no-color", "--no-ext-diff", "--no-prefix", "--relative", base, "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[string][]int)
+	for file, lines := range parseDiffHunks(diff) {
+		changed[filepath.Join(abs, filepath.FromSlash(file))] = lines
+	}
+
+	untracked, err := runGit(abs, "ls-files", "--others", "--exclude-standard", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range strings.Split(untracked, "\n") {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		filename := filepath.Join(abs, filepath.FromSlash(file))
+		changed[filename] = getAllLines(filename)
+	}
+	return changed, nil
+}
+
+// parseDiffHunks returns the added and modified lines of each file in a unified diff made with -U0 --no-prefix
+func parseDiffHunks(diff string) map[string][]int {
+	changed := make(map[string][]int)
+	var file string
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(line, "+++ ")
+			if file == "/dev/null" { // deleted file
+				file = ""
+			}
+		case file != "" && hunkHeader.MatchString(line):
+			match := hunkHeader.FindStringSubmatch(line)
+			start, _ := strconv.Atoi(match[1])
+			count := 1
+			if match[2] != "" {
+				count, _ = strconv.Atoi(match[2])
+			}
+			for i := 0; i < count; i++ {
+				changed[file] = append(changed[file], start+i)
+			}
+		}
+	}
+	return changed
+}
+
+func getAllLines(filename string) []int {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	lines := []int{}
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		lines = append(lines, i)
+	}
+	return lines
+}
+
+// keepStatementLines leaves only the changed lines which hold part of a statement. Blank lines, comments and
+// closing braces fall within coverage blocks too but aren't code which can run. Files which can't be parsed are
+// kept as they are
+func keepStatementLines(changed map[string][]int) map[string][]int {
+	kept := make(map[string][]int)
+	for filename, lines := range changed {
+		statements, ok := getStatementLines(filename)
+		if !ok {
+			kept[filename] = lines
+			continue
+		}
+		kept[filename] = []int{}
+		for _, line := range lines {
+			if statements[line] {
+				kept[filename] = append(kept[filename], line)
+			}
+		}
+	}
+	return kept
+}
+
+// getStatementLines returns the lines of a file with code belonging to a statement. Only the header of if, for,
+// switch and select statements and case clauses is counted, as their bodies are statements of their own
+func getStatementLines(filename string) (map[int]bool, bool) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, false
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, false
+	}
+
+	code := make(map[int]bool) // lines with tokens other than comments
+	var s scanner.Scanner
+	s.Init(fset.AddFile("", -1, len(src)), src, nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok != token.SEMICOLON || lit != "\n" { // leave out the semicolons inserted at line ends
+			code[fset.Position(pos).Line] = true
+		}
+	}
+
+	lines := make(map[int]bool)
+	add := func(start, end token.Pos) {
+		for line := fset.Position(start).Line; line <= fset.Position(end).Line; line++ {
+			lines[line] = lines[line] || code[line]
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+		case *ast.IfStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.ForStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.RangeStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.SwitchStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.TypeSwitchStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.SelectStmt:
+			add(n.Pos(), n.Body.Lbrace)
+		case *ast.CaseClause:
+			add(n.Pos(), n.Colon)
+		case *ast.CommClause:
+			add(n.Pos(), n.Colon)
+		case *ast.LabeledStmt:
+			add(n.Pos(), n.Colon)
+		case ast.Stmt:
+			add(n.Pos(), n.End())
+		}
+		return true
+	})
+	return lines, true
+}
+
+// getPatchCoverage intersects the changed lines with the profile blocks. A changed line within a block is covered
+// when any block containing it was run. resolve returns the absolute file name of a profile file
+func getPatchCoverage(blocks []ProfileBlock, changed map[string][]int, resolve func(file string) string) *PatchCoverage {
+	lineCoverage := make(map[string]map[int]bool)
+	for _, block := range blocks {
+		if block.NumStmt == 0 {
+			continue
+		}
+		lines, ok := changed[resolve(block.File)]
+		if !ok {
+			continue
+		}
+		for _, line := range lines {
+			if line < block.StartLine || line > block.EndLine {
+				continue
+			}
+			if lineCoverage[block.File] == nil {
+				lineCoverage[block.File] = make(map[int]bool)
+			}
+			lineCoverage[block.File][line] = lineCoverage[block.File][line] || block.Count > 0
+		}
+	}
+
+	patch := &PatchCoverage{Files: []PatchFile{}}
+	for file, lines := range lineCoverage {
+		patchFile := PatchFile{File: file, Total: len(lines), Uncovered: []int{}}
+		for line, covered := range lines {
+			if covered {
+				patchFile.Covered++
+			} else {
+				patchFile.Uncovered = append(patchFile.Uncovered, line)
+			}
+		}
+		sort.Ints(patchFile.Uncovered)
+		patch.Covered += patchFile.Covered
+		patch.Total += patchFile.Total
+		patch.Files = append(patch.Files, patchFile)
+	}
+	sort.Slice(patch.Files, func(i, j int) bool { return patch.Files[i].File < patch.Files[j].File })
+	return patch
+}
+
+// formatLineRanges joins sorted line numbers, collapsing consecutive lines into ranges, e.g. "3-5, 9"
+func formatLineRanges(lines []int) string {
+	ranges := []string{}
+	for i := 0; i < len(lines); i++ {
+		start := lines[i]
+		for i+1 < len(lines) && lines[i+1] == lines[i]+1 {
+			i++
+		}
+		if lines[i] == start {
+			ranges = append(ranges, strconv.Itoa(start))
+		} else {
+			ranges = append(ranges, strconv.Itoa(start)+"-"+strconv.Itoa(lines[i]))
+		}
+	}
+	return strings.Join(ranges, ", ")
+}
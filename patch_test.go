@@ -0,0 +1,128 @@
+package autotest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const patchDiff = `diff --git pkg/a.go pkg/a.go
+index 1111111..2222222 100644
+--- pkg/a.go
++++ pkg/a.go
+@@ -3,0 +4,2 @@ func A() {
++	x := 1
++	y := 2
+@@ -10 +12 @@ func A() {
+-	return 1
++	return x + y
+diff --git pkg/b.go pkg/b.go
+deleted file mode 100644
+--- pkg/b.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package pkg
+diff --git pkg/c.go pkg/c.go
+--- pkg/c.go
++++ pkg/c.go
+@@ -5,2 +4,0 @@
+-	removed()
+-	removed()
+`
+
+func TestParseDiffHunks(t *testing.T) {
+	assert.Equal(t, map[string][]int{"pkg/a.go": {4, 5, 12}}, parseDiffHunks(patchDiff))
+	assert.Empty(t, parseDiffHunks(""))
+}
+
+func TestGetPatchCoverage(t *testing.T) {
+	blocks := []ProfileBlock{
+		{File: "example.com/m/a.go", StartLine: 3, EndLine: 6, NumStmt: 2, Count: 1},
+		{File: "example.com/m/a.go", StartLine: 6, EndLine: 8, NumStmt: 2, Count: 0},
+		{File: "example.com/m/a.go", StartLine: 9, EndLine: 9, NumStmt: 0, Count: 0}, // no statements
+		{File: "example.com/m/a.go", StartLine: 10, EndLine: 12, NumStmt: 1, Count: 0},
+		{File: "example.com/m/b.go", StartLine: 1, EndLine: 5, NumStmt: 3, Count: 1},
+		{File: "example.com/m/c.go", StartLine: 1, EndLine: 5, NumStmt: 3, Count: 0}, // unchanged file
+	}
+	changed := map[string][]int{
+		"/m/a.go": {4, 6, 7, 9, 11, 12},
+		"/m/b.go": {2},
+	}
+	patch := getPatchCoverage(blocks, changed, func(file string) string { return "/m/" + filepath.Base(file) })
+	assert.Equal(t, []PatchFile{
+		{File: "example.com/m/a.go", Covered: 2, Total: 5, Uncovered: []int{7, 11, 12}},
+		{File: "example.com/m/b.go", Covered: 1, Total: 1, Uncovered: []int{}},
+	}, patch.Files)
+	assert.Equal(t, 3, patch.Covered)
+	assert.Equal(t, 6, patch.Total)
+	assert.Equal(t, float32(50), patch.Percent())
+	assert.False(t, patch.BelowThreshold())
+	patch.Threshold = 80
+	assert.True(t, patch.BelowThreshold())
+
+	empty := getPatchCoverage(blocks, nil, filepath.Base)
+	assert.Equal(t, float32(100), empty.Percent())
+	empty.Threshold = 80
+	assert.False(t, empty.BelowThreshold(), "nothing changed")
+}
+
+func TestGetChangedLines(t *testing.T) {
+	exec = execfactory.NewOSCreator()
+	root := gitRepo(t)
+	writeFiles(t, root, map[string]string{
+		"pkg/pkg.go":   "package pkg\n\nfunc A() {}\n",
+		"pkg/new.go":   "package pkg\n\nfunc B() {}\n",
+		"pkg/notes.md": "not go\n",
+		"other.go":     "package repo\n",
+	})
+	changed, err := getChangedLines(filepath.Join(root, "pkg"), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]int{
+		filepath.Join(root, "pkg", "pkg.go"): {2, 3},
+		filepath.Join(root, "pkg", "new.go"): {1, 2, 3},
+	}, changed)
+
+	_, err = getChangedLines(filepath.Join(root, "pkg"), "unknown-ref")
+	assert.Error(t, err)
+	_, err = getChangedLines(t.TempDir(), "HEAD")
+	assert.Error(t, err, "not a git repository")
+	assert.Nil(t, getAllLines(filepath.Join(root, "missing.go")))
+}
+
+func TestKeepStatementLines(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a.go": `package a
+
+// A adds
+func A(a, b int) int {
+	x := a
+
+	// comment inside a block
+	if x > 0 {
+		x += b
+	} else {
+		x -= b
+	}
+	switch {
+	case x > 10:
+		return 10
+	}
+	/* block
+	   comment */
+	return x
+}
+`, "notes.txt": "not go"})
+	a, notes := filepath.Join(root, "a.go"), filepath.Join(root, "notes.txt")
+	kept := keepStatementLines(map[string][]int{a: {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}, notes: {1}})
+	assert.Equal(t, []int{5, 8, 9, 11, 13, 14, 15, 19}, kept[a])
+	assert.Equal(t, []int{1}, kept[notes], "files which don't parse are kept")
+}
+
+func TestFormatLineRanges(t *testing.T) {
+	assert.Equal(t, "", formatLineRanges(nil))
+	assert.Equal(t, "3", formatLineRanges([]int{3}))
+	assert.Equal(t, "3-5, 9, 11-12", formatLineRanges([]int{3, 4, 5, 9, 11, 12}))
+}
@@ -0,0 +1,50 @@
+package autotest
+
+import (
+	"errors"
+	"io"
+)
+
+// ReplayLog reads a saved or externally produced go test -json log and groups it into a result for each package
+// so it can be printed the same way as a live run
+func ReplayLog(r io.Reader) ([]*TestResult, error) {
+	output, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := getTestEvents(output, 0)
+	var buildErr *BuildError
+	if err != nil && !errors.As(err, &buildErr) {
+		return nil, err
+	}
+
+	results := []*TestResult{}
+	byPackage := make(map[string]*TestResult)
+	getResult := func(pkg string) *TestResult {
+		if result, ok := byPackage[pkg]; ok {
+			return result
+		}
+		result := &TestResult{Folder: pkg}
+		byPackage[pkg] = result
+		results = append(results, result)
+		return result
+	}
+	for _, status := range statuses {
+		result := getResult(status.Package)
+		result.Status = append(result.Status, status)
+	}
+	if buildErr != nil {
+		for pkg, pkgOutput := range buildErr.Packages {
+			if pkgOutput == "" {
+				pkgOutput = buildErr.Output
+			}
+			getResult(pkg).Error = &BuildError{Output: pkgOutput, Packages: map[string]string{pkg: pkgOutput}}
+		}
+	}
+	return results, nil
+}
+
+// HasFailures returns true when the result failed to build or has failing tests
+func (r *TestResult) HasFailures() bool {
+	return hasFailures(r)
+}
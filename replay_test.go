@@ -0,0 +1,33 @@
+package autotest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayLog(t *testing.T) {
+	otherPackage := `{"Time":"2019-09-25T18:24:29.865105Z","Action":"fail","Package":"github.com/robarchibald/other","Test":"TestFail","Elapsed":0.2}`
+	results, err := ReplayLog(strings.NewReader(testOutput + "\n" + otherPackage + "\n" + buildEventOutput))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(results))
+
+	assert.Equal(t, "github.com/robarchibald/autotest/cmd", results[0].Folder)
+	assert.Equal(t, 2, len(results[0].Status))
+	assert.False(t, results[0].HasFailures())
+
+	assert.Equal(t, "github.com/robarchibald/other", results[1].Folder)
+	assert.True(t, results[1].HasFailures())
+
+	assert.Equal(t, "github.com/robarchibald/autotest", results[2].Folder)
+	assert.Equal(t, "# github.com/robarchibald/autotest\n./console.go:66:2: undefined: x", results[2].Error.Error())
+}
+
+func TestReplayLogStrayOutput(t *testing.T) {
+	results, err := ReplayLog(strings.NewReader("some text\n" + testOutput))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(results))
+	assert.Nil(t, results[0].Error)
+}
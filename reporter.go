@@ -0,0 +1,352 @@
+package autotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+)
+
+// Reporter receives the progress and results of test runs. Events of different folders may be reported at the
+// same time
+type Reporter interface {
+	RunStarted(folder string)
+	TestEvent(folder string, status TestStatus) // each test and package of a finished run
+	RunFinished(result *TestResult)             // the full results of a run
+	CoverageDiff(folder string, diff *TestResult)
+	BuildError(result *TestResult)
+	Integration(result *IntegrationResult) // the outcome of the integration command
+	Message(message string)                // a note about autotest itself, e.g. a setting changed by a key press
+	Error(err error)                       // a failure of autotest itself rather than of the tests
+	Summary(summary *Summary)
+}
+
+// Summary counts the tests of the last run of each folder
+type Summary struct {
+	Folders int
+	Passed  int
+	Failed  int
+	Skipped int
+	Failing []string // folders with failing tests or build errors
+}
+
+// Summarize counts the tests of the results
+func Summarize(results []*TestResult) *Summary {
+	summary := &Summary{Folders: len(results), Failing: []string{}}
+	for _, result := range results {
+		if hasFailures(result) {
+			summary.Failing = append(summary.Failing, result.Folder)
+		}
+		for _, status := range result.Status {
+			if status.Test == "" {
+				continue
+			}
+			switch status.TestResult {
+			case "pass":
+				summary.Passed++
+			case "fail":
+				summary.Failed++
+			case "skip":
+				summary.Skipped++
+			}
+		}
+	}
+	return summary
+}
+
+// ConsoleReporter prints the changes of each run as colored tables
+type ConsoleReporter struct {
+	ShowCoverage bool    // print the code coverage section. See ToggleCoverage to change it while reporting
+	CRAPCutoff   float32 // leave functions with a lower CRAP score out of the code coverage section, which lists the riskiest first
+	HottestLines int     // number of most executed lines listed after the code coverage in count and atomic mode. 0 disables
+
+	out   io.Writer
+	mutex sync.Mutex // events are printed one at a time
+}
+
+// NewConsoleReporter returns a reporter printing to out, showing the code coverage section and the hottest lines
+func NewConsoleReporter(out io.Writer) *ConsoleReporter {
+	return &ConsoleReporter{ShowCoverage: true, HottestLines: DefaultHottestLines, out: out}
+}
+
+// ToggleCoverage turns the code coverage section on or off and returns whether it is now shown
+func (c *ConsoleReporter) ToggleCoverage() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ShowCoverage = !c.ShowCoverage
+	return c.ShowCoverage
+}
+
+// Message prints a line about autotest itself rather than a test run, e.g. a setting changed by a key press
+func (c *ConsoleReporter) Message(message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.println(message)
+}
+
+// Error prints a failure of autotest itself in red
+func (c *ConsoleReporter) Error(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.println(aurora.Red(err.Error()))
+}
+
+// Integration prints the integration coverage, along with the output of the command when it failed
+func (c *ConsoleReporter) Integration(result *IntegrationResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.PrintIntegration(result)
+}
+
+// RunStarted prints the folder being tested
+func (c *ConsoleReporter) RunStarted(folder string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.println("\nrunning tests for", folder)
+}
+
+// TestEvent does nothing. The tests are listed with the changes of the run
+func (c *ConsoleReporter) TestEvent(string, TestStatus) {}
+
+// RunFinished does nothing. Only the changes since the baseline are printed
+func (c *ConsoleReporter) RunFinished(*TestResult) {}
+
+// CoverageDiff prints the changes of a run since the baseline, or that nothing changed when diff is nil
+func (c *ConsoleReporter) CoverageDiff(folder string, diff *TestResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if diff == nil {
+		c.println("unchanged")
+		return
+	}
+	c.PrintTest(diff)
+}
+
+// BuildError prints the build failure along with the results of any packages which did build
+func (c *ConsoleReporter) BuildError(result *TestResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.PrintTest(result)
+}
+
+// Summary prints the number of tests passed, failed and skipped and lists the failing folders
+func (c *ConsoleReporter) Summary(summary *Summary) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.println()
+	c.printHeader("--- Summary ---", fmt.Sprintf("%d packages", summary.Folders))
+	c.println(aurora.Green(fmt.Sprintf("%d passed", summary.Passed)), aurora.Red(fmt.Sprintf("%d failed", summary.Failed)), aurora.Yellow(fmt.Sprintf("%d skipped", summary.Skipped)))
+	for _, folder := range summary.Failing {
+		c.println(printTestResult("fail"), folder)
+	}
+}
+
+func (c *ConsoleReporter) println(a ...interface{}) {
+	fmt.Fprintln(c.out, a...)
+}
+
+func (c *ConsoleReporter) printf(format string, a ...interface{}) {
+	fmt.Fprintf(c.out, format, a...)
+}
+
+func (c *ConsoleReporter) print(a ...interface{}) {
+	fmt.Fprint(c.out, a...)
+}
+
+// MultiReporter sends every event to each of its reporters in turn
+type MultiReporter []Reporter
+
+// NewMultiReporter returns a reporter sending events to all of the reporters
+func NewMultiReporter(reporters ...Reporter) MultiReporter {
+	return MultiReporter(reporters)
+}
+
+// RunStarted reports the start of a run to each reporter
+func (m MultiReporter) RunStarted(folder string) {
+	for _, r := range m {
+		r.RunStarted(folder)
+	}
+}
+
+// TestEvent reports a test or package result to each reporter
+func (m MultiReporter) TestEvent(folder string, status TestStatus) {
+	for _, r := range m {
+		r.TestEvent(folder, status)
+	}
+}
+
+// RunFinished reports the full results of a run to each reporter
+func (m MultiReporter) RunFinished(result *TestResult) {
+	for _, r := range m {
+		r.RunFinished(result)
+	}
+}
+
+// CoverageDiff reports the changes of a run since the baseline to each reporter
+func (m MultiReporter) CoverageDiff(folder string, diff *TestResult) {
+	for _, r := range m {
+		r.CoverageDiff(folder, diff)
+	}
+}
+
+// BuildError reports a build failure to each reporter
+func (m MultiReporter) BuildError(result *TestResult) {
+	for _, r := range m {
+		r.BuildError(result)
+	}
+}
+
+// Integration reports the outcome of the integration command to each reporter
+func (m MultiReporter) Integration(result *IntegrationResult) {
+	for _, r := range m {
+		r.Integration(result)
+	}
+}
+
+// Message reports a note about autotest itself to each reporter
+func (m MultiReporter) Message(message string) {
+	for _, r := range m {
+		r.Message(message)
+	}
+}
+
+// Error reports a failure of autotest itself to each reporter
+func (m MultiReporter) Error(err error) {
+	for _, r := range m {
+		r.Error(err)
+	}
+}
+
+// Summary reports the test counts of every folder to each reporter
+func (m MultiReporter) Summary(summary *Summary) {
+	for _, r := range m {
+		r.Summary(summary)
+	}
+}
+
+// Event names written by the JSONReporter
+const (
+	EventRunStarted   = "run-started"
+	EventTest         = "test"
+	EventRunFinished  = "run-finished"
+	EventCoverageDiff = "coverage-diff"
+	EventBuildError   = "build-error"
+	EventIntegration  = "integration"
+	EventMessage      = "message"
+	EventError        = "error"
+	EventSummary      = "summary"
+)
+
+// JSONEvent is a line written by the JSONReporter. Only the fields of the event are set
+type JSONEvent struct {
+	Time        time.Time
+	Event       string
+	Folder      string             `json:",omitempty"`
+	Mode        string             `json:",omitempty"`
+	Test        *TestStatus        `json:",omitempty"`
+	Message     string             `json:",omitempty"`
+	Error       string             `json:",omitempty"`
+	Coverage    []FunctionCoverage `json:",omitempty"`
+	Patch       *PatchCoverage     `json:",omitempty"`
+	Unchanged   bool               `json:",omitempty"` // nothing changed since the baseline
+	Integration *JSONIntegration   `json:",omitempty"`
+	Summary     *Summary           `json:",omitempty"`
+}
+
+// JSONIntegration is the outcome of the integration command in an integration event
+type JSONIntegration struct {
+	ExitCode int
+	Output   string   `json:",omitempty"` // only kept when the command failed
+	Percent  *float32 `json:",omitempty"` // statement coverage of the command, when it could be read
+}
+
+// JSONReporter writes each event as a line of JSON, like go test -json
+type JSONReporter struct {
+	encoder *json.Encoder
+	mutex   sync.Mutex
+	now     func() time.Time
+}
+
+// NewJSONReporter returns a reporter writing to w
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{encoder: json.NewEncoder(w), now: time.Now}
+}
+
+// RunStarted writes a run-started event
+func (j *JSONReporter) RunStarted(folder string) {
+	j.write(JSONEvent{Event: EventRunStarted, Folder: folder})
+}
+
+// TestEvent writes a test event for each test and package of a finished run
+func (j *JSONReporter) TestEvent(folder string, status TestStatus) {
+	j.write(JSONEvent{Event: EventTest, Folder: folder, Test: &status})
+}
+
+// RunFinished writes a run-finished event with the full results of a run
+func (j *JSONReporter) RunFinished(result *TestResult) {
+	j.write(getResultEvent(EventRunFinished, result))
+}
+
+// CoverageDiff writes a coverage-diff event with the changes since the baseline, marked unchanged when diff is nil
+func (j *JSONReporter) CoverageDiff(folder string, diff *TestResult) {
+	if diff == nil {
+		j.write(JSONEvent{Event: EventCoverageDiff, Folder: folder, Unchanged: true})
+		return
+	}
+	j.write(getResultEvent(EventCoverageDiff, diff))
+}
+
+// BuildError writes a build-error event
+func (j *JSONReporter) BuildError(result *TestResult) {
+	j.write(getResultEvent(EventBuildError, result))
+}
+
+// Integration writes an integration event
+func (j *JSONReporter) Integration(result *IntegrationResult) {
+	integration := &JSONIntegration{ExitCode: result.ExitCode}
+	if result.ExitCode != 0 {
+		integration.Output = result.Output
+	}
+	if result.Profile != nil {
+		percent := getStatementCoverage(result.Profile.Blocks)
+		integration.Percent = &percent
+	}
+	event := JSONEvent{Event: EventIntegration, Integration: integration}
+	if result.Error != nil {
+		event.Error = result.Error.Error()
+	}
+	j.write(event)
+}
+
+// Message writes a message event
+func (j *JSONReporter) Message(message string) {
+	j.write(JSONEvent{Event: EventMessage, Message: message})
+}
+
+// Error writes an error event
+func (j *JSONReporter) Error(err error) {
+	j.write(JSONEvent{Event: EventError, Error: err.Error()})
+}
+
+// Summary writes a summary event
+func (j *JSONReporter) Summary(summary *Summary) {
+	j.write(JSONEvent{Event: EventSummary, Summary: summary})
+}
+
+func getResultEvent(event string, result *TestResult) JSONEvent {
+	e := JSONEvent{Event: event, Folder: result.Folder, Mode: result.Mode, Coverage: result.Coverage, Patch: result.Patch}
+	if result.Error != nil {
+		e.Error = result.Error.Error()
+	}
+	return e
+}
+
+func (j *JSONReporter) write(event JSONEvent) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	event.Time = j.now()
+	j.encoder.Encode(event)
+}
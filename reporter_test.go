@@ -0,0 +1,103 @@
+package autotest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarize(t *testing.T) {
+	summary := Summarize([]*TestResult{
+		{Folder: "a", Status: []TestStatus{{Test: "TestA", TestResult: "pass"}, {Test: "TestB", TestResult: "skip"}, {TestResult: "pass"}}},
+		{Folder: "b", Status: []TestStatus{{Test: "TestC", TestResult: "fail"}, {Test: "TestD", TestResult: "pass"}}},
+		{Folder: "c", Error: errors.New("build failed")},
+	})
+	assert.Equal(t, &Summary{Folders: 3, Passed: 2, Failed: 1, Skipped: 1, Failing: []string{"b", "c"}}, summary)
+}
+
+func TestConsoleReporter(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	c.RunStarted("pkg")
+	c.TestEvent("pkg", TestStatus{Test: "TestA", TestResult: "pass"})
+	c.RunFinished(&TestResult{Folder: "pkg"})
+	c.CoverageDiff("pkg", nil)
+	assert.Equal(t, "\nrunning tests for pkg\nunchanged\n", printed.String())
+
+	printed.Reset()
+	c.CoverageDiff("pkg", &TestResult{Folder: "pkg"})
+	assert.Equal(t, "\n"+strings.Repeat("-", 38)+" pkg "+strings.Repeat("-", 38)+"\n", printed.String())
+
+	printed.Reset()
+	c.BuildError(&TestResult{Folder: "pkg", Error: errors.New("./a.go:3:1: syntax error")})
+	assert.Contains(t, printed.String(), aurora.Red("syntax error").String())
+
+	printed.Reset()
+	c.Summary(&Summary{Folders: 2, Passed: 3, Failed: 1, Failing: []string{"pkg"}})
+	assert.Equal(t, "\n "+aurora.Blue("--- Summary ---").String()+"\n"+getColumns([]string{"2 packages"})+"\n"+
+		aurora.Green("3 passed").String()+" "+aurora.Red("1 failed").String()+" "+aurora.Yellow("0 skipped").String()+"\n"+
+		printTestResult("fail")+" pkg\n", printed.String())
+}
+
+func TestConsoleReporterSettings(t *testing.T) {
+	var printed strings.Builder
+	c := NewConsoleReporter(&printed)
+	assert.True(t, c.ShowCoverage)
+	assert.Equal(t, DefaultHottestLines, c.HottestLines)
+	result := &TestResult{Folder: "pkg", Coverage: []FunctionCoverage{{Filename: "a.go", Function: "Get", CoveragePercent: 50}}}
+	c.PrintTest(result)
+	assert.Contains(t, printed.String(), "Code Coverage")
+
+	printed.Reset()
+	assert.False(t, c.ToggleCoverage())
+	c.PrintTest(result)
+	assert.NotContains(t, printed.String(), "Code Coverage")
+
+	printed.Reset()
+	c.Message("baseline reset")
+	assert.Equal(t, "baseline reset\n", printed.String())
+
+	printed.Reset()
+	c.Error(errors.New("unable to export coverage"))
+	assert.Contains(t, printed.String(), "unable to export coverage")
+
+	printed.Reset()
+	c.Integration(&IntegrationResult{ExitCode: 2, Output: "FAIL"})
+	assert.Contains(t, printed.String(), "integration command failed with exit code 2")
+}
+
+func TestMultiReporter(t *testing.T) {
+	var first, second strings.Builder
+	now := func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	a, b := NewJSONReporter(&first), NewJSONReporter(&second)
+	a.now, b.now = now, now
+	m := NewMultiReporter(a, b)
+	m.RunStarted("pkg")
+	m.TestEvent("pkg", TestStatus{Package: "example.com/pkg", Test: "TestA", TestResult: "pass", Elapsed: 0.5})
+	m.RunFinished(&TestResult{Folder: "pkg", Mode: RunModeFocus, Coverage: []FunctionCoverage{{Filename: "a.go", Function: "Get", CoveragePercent: 50}}})
+	m.CoverageDiff("pkg", nil)
+	m.BuildError(&TestResult{Folder: "pkg", Error: errors.New("syntax error")})
+	m.Integration(&IntegrationResult{ExitCode: 1, Output: "FAIL", Profile: &Profile{Blocks: []ProfileBlock{{StartLine: 1, NumStmt: 1, Count: 1}, {StartLine: 2, NumStmt: 3}}}})
+	m.Integration(&IntegrationResult{Output: "ok", Error: errors.New("no coverage")})
+	m.Message("baseline reset")
+	m.Error(errors.New("unable to export coverage"))
+	m.Summary(&Summary{Folders: 1, Passed: 1, Failing: []string{}})
+
+	expected := `{"Time":"2024-01-02T03:04:05Z","Event":"run-started","Folder":"pkg"}
+{"Time":"2024-01-02T03:04:05Z","Event":"test","Folder":"pkg","Test":{"Elapsed":0.5,"Package":"example.com/pkg","Test":"TestA","TestResult":"pass","Output":"","SkipReason":""}}
+{"Time":"2024-01-02T03:04:05Z","Event":"run-finished","Folder":"pkg","Mode":"focus","Coverage":[{"Path":"","Filename":"a.go","Function":"Get","LineNumber":0,"CoveragePercent":50,"AnyTestsPercent":0,"CrossPackage":false,"CoveredBy":null,"Complexity":0,"CRAP":0,"Branches":null,"Nondeterministic":false,"IntegrationPercent":0,"Integration":false}]}
+{"Time":"2024-01-02T03:04:05Z","Event":"coverage-diff","Folder":"pkg","Unchanged":true}
+{"Time":"2024-01-02T03:04:05Z","Event":"build-error","Folder":"pkg","Error":"syntax error"}
+{"Time":"2024-01-02T03:04:05Z","Event":"integration","Integration":{"ExitCode":1,"Output":"FAIL","Percent":25}}
+{"Time":"2024-01-02T03:04:05Z","Event":"integration","Error":"no coverage","Integration":{"ExitCode":0}}
+{"Time":"2024-01-02T03:04:05Z","Event":"message","Message":"baseline reset"}
+{"Time":"2024-01-02T03:04:05Z","Event":"error","Error":"unable to export coverage"}
+{"Time":"2024-01-02T03:04:05Z","Event":"summary","Summary":{"Folders":1,"Passed":1,"Failed":0,"Skipped":0,"Failing":[]}}
+`
+	assert.Equal(t, expected, first.String())
+	assert.Equal(t, expected, second.String())
+}
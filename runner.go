@@ -4,32 +4,68 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
-	"fmt"
+	"io"
+	osexec "os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/EndFirstCorp/execfactory"
 )
 
-var basicTestArgs = []string{"test", "-json", "-short", "-timeout", "5s"}
+func runCoverageArgs(options RunOptions, profile, pkg string) []string {
+	args := []string{"test", "-json", "-short", "-coverprofile", profile, "-timeout", "5s"}
+	if options.CoverMode != "" {
+		args = append(args, "-covermode", options.CoverMode)
+	}
+	if options.CoverPkg {
+		args = append(args, "-coverpkg", getCoverPkgArg(options.Module))
+	}
+	if options.RunRegex != "" {
+		args = append(args, "-run", options.RunRegex)
+	}
+	return append(args, pkg)
+}
 
-func runCoverageArgs(tempDir string) []string {
-	return []string{"test", "-json", "-short", "-coverprofile", filepath.Join(tempDir, "cover.out"), "-timeout", "5s"}
+func getCoverageArgs(profile string) []string {
+	return []string{"tool", "cover", "-func", profile}
 }
 
-func getCoverageArgs(tempDir string) []string {
-	return []string{"tool", "cover", "-func", filepath.Join(tempDir, "cover.out")}
+// RunOptions contains the settings used to run the tests for a folder
+type RunOptions struct {
+	TempDir  string  // run artifacts are saved in a folder per package and run within TempDir
+	Retain   int     // number of runs kept per package. Defaults to DefaultRetain
+	Module   *Module // when set, tests are run from the module root instead of the folder
+	RunRegex string  // passed to go test -run when set
+	Focus    bool    // run only the previously failing tests until they pass. Ignored when RunRegex is set. Tests
+	// selected with SelectTests are run either way
+
+	CoverageExcludes []string // glob patterns of files left out of coverage, relative to the module
+	IncludeGenerated bool     // include files with a "// Code generated ... DO NOT EDIT." header in coverage
+	CoverPkg         bool     // measure the coverage of every package in the module with -coverpkg
+	CoverMode        string   // passed to go test -covermode when set. count and atomic record how often lines run
+	ChangedTests     bool     // snapshot the folder's files on full runs so ChangedTests can find the tests changed since
+
+	PatchBase      string  // git ref the changed lines are found against. Patch coverage is skipped when empty
+	PatchThreshold float32 // minimum percentage of changed lines which must be covered. 0 disables
 }
 
 // TestResult contains the full results of a test run
 type TestResult struct {
-	Folder   string
-	Error    error
-	Status   []TestStatus
-	Coverage []FunctionCoverage
+	Folder     string
+	ModulePath string
+	Mode       string
+	Artifacts  *RunArtifacts
+	Error      error
+	Status     []TestStatus
+	Coverage   []FunctionCoverage
+	Profile    *Profile       // coverage profile without the excluded files and functions
+	Patch      *PatchCoverage // coverage of the lines changed since RunOptions.PatchBase
+	SourceHash string         // hash of the folder's go files when the tests ran
+
+	NewlySkipped []string // tests skipped in this run which weren't skipped in the previous run
 }
 
 // TestStatus contains the status for a single test run
@@ -39,39 +75,139 @@ type TestStatus struct {
 	Test       string
 	TestResult string
 	Output     string
+	SkipReason string
 }
 
 // FunctionCoverage contains the code coverage for a function
 type FunctionCoverage struct {
+	Path            string // import path of the file
 	Filename        string
 	Function        string
 	LineNumber      int
-	CoveragePercent float32
+	CoveragePercent float32 // coverage by the package's own tests
+	AnyTestsPercent float32 // coverage by the tests of any package in the module. Set when CrossPackage is true
+	CrossPackage    bool
+	CoveredBy       []string // tests which run the function, from the TestIndex
+	Complexity      int      // cyclomatic complexity. 0 when the source couldn't be read
+	CRAP            float32  // risk score combining complexity and coverage, see getCRAP
+	Branches        []Branch // branches of the function's if, switch and select statements
+
+	Nondeterministic bool // coverage changes between runs without code changes
+
+	IntegrationPercent float32 // coverage by the package's own tests and the integration command. Set when Integration is true
+	Integration        bool
 }
 
 type testEvent struct {
-	Time    time.Time
-	Action  string
-	Package string
-	Test    string
-	Elapsed float64 // seconds
-	Output  string
+	Time        time.Time
+	Action      string
+	Package     string
+	Test        string
+	Elapsed     float64 // seconds
+	Output      string
+	ImportPath  string // set on build-output and build-fail actions
+	FailedBuild string // set on a package fail action when the failure was caused by a build error
 }
 
 var exec = execfactory.NewOSCreator()
 
 // RunTests will run a new set of tests whenever a file changes
-func RunTests(folder, tempDir string) *TestResult {
-	status, err := runGoTest(folder, tempDir)
-	result := &TestResult{Folder: folder, Status: status, Error: err}
-	if err != nil { // skip coverage
+func RunTests(folder string, options RunOptions) *TestResult {
+	dir, pkg := folder, "."
+	result := &TestResult{Folder: folder}
+	if options.Module != nil {
+		dir, pkg = options.Module.Dir, options.Module.PackageArg(folder)
+		result.ModulePath = options.Module.Path
+	}
+	if options.RunRegex != "" {
+		result.Mode = RunModeFiltered
+	} else {
+		options.RunRegex, result.Mode = getFocusRun(folder, options.Focus)
+	}
+	var sources map[string]string // later test file changes are compared with the files as this run started
+	if options.ChangedTests && (options.RunRegex == "" || result.Mode == RunModeChanged) {
+		_, sources, _ = readFolderSources(folder)
+	}
+	result.SourceHash, _ = hashFolder(folder)
+	artifacts, err := newRunArtifacts(options.TempDir, folder, options.Retain)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.Artifacts = artifacts
+
+	args := runCoverageArgs(options, artifacts.Profile, pkg)
+	start := time.Now()
+	stdout, stderr, exitCode := runGoToolOutput(dir, args)
+	if err := artifacts.save(stdout, stderr, RunTiming{Folder: folder, Args: args, Start: start, End: time.Now(), ExitCode: exitCode}); err != nil {
+		result.Error = err
 		return result
 	}
-	out, _ := runGoTool(folder, getCoverageArgs(tempDir))
+	result.Status, result.Error = getTestEvents(append(stdout, stderr...), exitCode)
+	if result.Error == nil && sources != nil { // a run which didn't build says nothing about the tests
+		snapshotFolder(folder, sources)
+	}
+	if result.Error != nil || result.IsPartial() { // skip coverage. Focused runs only cover part of the package
+		return result
+	}
+	out, _ := runGoTool(dir, getCoverageArgs(artifacts.Profile))
 	result.Coverage = getCoverage(out)
+	if profile, err := readProfile(artifacts.Profile); err == nil {
+		filter := newCoverageFilter(options.Module, folder, options)
+		result.Profile = &Profile{Mode: profile.Mode, Blocks: filter.includedBlocks(profile.Blocks)}
+		result.Profile.resolveFilenames(options.Module, folder)
+		own := result.Profile
+		if options.CoverPkg { // the profile covers the whole module. Only the package's own functions are listed
+			own = result.Profile.folderProfile(folder)
+			module := recordFolderProfile(folder, result.Profile)
+			result.Coverage = getCrossPackageCoverage(result.Coverage, own, module, filter.sources)
+			if err := writeFileAtomic(filepath.Join(options.TempDir, ModuleProfileName), func(w io.Writer) error { return writeProfile(w, module) }); err != nil {
+				result.Error = err
+				return result
+			}
+		}
+		if integration := getIntegrationProfile(result.Profile.Filenames); integration != nil { // add the coverage of the integration command
+			integration.Blocks = filter.includedBlocks(integration.Blocks)
+			result.Coverage = getIntegrationCoverage(result.Coverage, own, integration, filter.sources)
+			result.Profile = mergeProfiles(result.Profile, integration)
+		}
+		result.Coverage = applyCoverageExclusions(result.Coverage, own, filter)
+		addRiskScores(result.Coverage, own, filter.sources)
+		addBranchCoverage(result.Coverage, own, filter.sources)
+		result.Patch = getFolderPatchCoverage(folder, result.Profile, options)
+	}
 	return result
 }
 
+// getFolderPatchCoverage returns the coverage of the lines changed in folder, or nil when it is disabled or the
+// folder isn't in a git repository
+func getFolderPatchCoverage(folder string, profile *Profile, options RunOptions) *PatchCoverage {
+	if options.PatchBase == "" {
+		return nil
+	}
+	changed, err := getChangedLines(folder, options.PatchBase)
+	if err != nil {
+		return nil
+	}
+	patch := getPatchCoverage(profile.Blocks, keepStatementLines(changed), func(file string) string { return profile.Filenames[file] })
+	patch.Base, patch.Threshold = options.PatchBase, options.PatchThreshold
+	return patch
+}
+
+// RunRegexForTests returns an anchored -run expression matching the top level tests of the named tests
+func RunRegexForTests(tests []string) string {
+	quoted := []string{}
+	seen := make(map[string]bool)
+	for _, test := range tests {
+		test = strings.SplitN(test, "/", 2)[0]
+		if !seen[test] {
+			seen[test] = true
+			quoted = append(quoted, regexp.QuoteMeta(test))
+		}
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
 func hasAnyPrefix(input string, prefixes []string) bool {
 	for _, prefix := range prefixes {
 		if strings.HasPrefix(input, prefix) {
@@ -81,6 +217,15 @@ func hasAnyPrefix(input string, prefixes []string) bool {
 	return false
 }
 
+func hasAnySuffix(input string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(input, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 func runGoTool(folder string, args []string) ([]byte, int) {
 	var exitCode int
 	cmd := exec.Command("go", args...)
@@ -89,40 +234,145 @@ func runGoTool(folder string, args []string) ([]byte, int) {
 	return out, exitCode
 }
 
-func runGoTest(folder, tempDir string) ([]TestStatus, error) {
-	var err error
-	var testOut []byte
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		out, exitCode := runGoTool(folder, basicTestArgs) // without -coverprofile which can cause false success on build failure
-		if exitCode != 0 {
-			_, err = getTestEvents(out)
-		}
-		wg.Done()
-	}()
-	go func() {
-		testOut, _ = runGoTool(folder, runCoverageArgs(tempDir))
-		wg.Done()
-	}()
-	wg.Wait()
-	if err != nil {
-		return nil, err
+// runGoToolOutput runs a go command keeping stdout and stderr separate
+func runGoToolOutput(folder string, args []string) ([]byte, []byte, int) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.SetDir(folder)
+	cmd.SetStdout(&stdout)
+	cmd.SetStderr(&stderr)
+	if err := cmd.Run(); err != nil {
+		if ee, ok := err.(*osexec.ExitError); ok {
+			return stdout.Bytes(), stderr.Bytes(), ee.ExitCode()
+		}
+		stderr.WriteString(err.Error())
+		return stdout.Bytes(), stderr.Bytes(), -1
 	}
-	return getTestEvents(testOut)
+	return stdout.Bytes(), stderr.Bytes(), 0
 }
 
-func getTestEvents(output []byte) ([]TestStatus, error) {
+func getTestEvents(output []byte, exitCode int) ([]TestStatus, error) {
+	events, stray := parseTestEvents(output)
 	results := []testEvent{}
+	for _, event := range events {
+		if event.Action != "build-output" && event.Action != "build-fail" {
+			results = append(results, event)
+		}
+	}
+	if err := getBuildError(output, events, stray, exitCode); err != nil {
+		return groupTestEvents(results), err
+	}
+	return groupTestEvents(results), nil
+}
+
+// parseTestEvents returns the events of the JSON stream along with the lines which aren't part of it
+func parseTestEvents(output []byte) ([]testEvent, []string) {
+	events := []testEvent{}
+	stray := []string{}
 	scanner := bufio.NewScanner(bytes.NewReader(output))
 	for scanner.Scan() {
-		line, ok := parseTestEventLine(scanner.Bytes())
-		if !ok {
-			return nil, fmt.Errorf(string(output))
+		event, ok := parseTestEventLine(scanner.Bytes())
+		if !ok { // compiler errors and go command messages are not always part of the JSON stream
+			stray = append(stray, scanner.Text())
+			continue
 		}
-		results = append(results, *line)
+		events = append(events, *event)
 	}
-	return groupTestEvents(results), nil
+	return events, stray
+}
+
+// BuildError contains the output of a failed build. Packages contains the build output for each import path that
+// failed to build
+type BuildError struct {
+	Output   string
+	Packages map[string]string
+}
+
+func (e *BuildError) Error() string {
+	return e.Output
+}
+
+// getBuildError checks the event stream for a build failure. Build failures are reported by build-output and
+// build-fail actions in newer versions of Go, by a "[build failed]" package output in older versions and, as a
+// last resort, by a non-zero exit code without any failed test to account for it
+func getBuildError(output []byte, events []testEvent, stray []string, exitCode int) *BuildError {
+	var buf strings.Builder
+	var testFailed bool
+	failed := []string{}
+	packageOutput := make(map[string]*strings.Builder)
+	addFailed := func(pkg string) {
+		if _, ok := packageOutput[pkg]; !ok {
+			packageOutput[pkg] = &strings.Builder{}
+			failed = append(failed, pkg)
+		}
+	}
+	for _, event := range events {
+		switch event.Action {
+		case "build-output":
+			pkg := getBuildPackage(event.ImportPath)
+			addFailed(pkg)
+			packageOutput[pkg].WriteString(event.Output)
+			buf.WriteString(event.Output)
+		case "build-fail":
+			addFailed(getBuildPackage(event.ImportPath))
+		case "fail":
+			if event.FailedBuild != "" {
+				addFailed(getBuildPackage(event.FailedBuild))
+			} else {
+				testFailed = true
+			}
+		case "output":
+			if hasAnySuffix(strings.TrimSpace(event.Output), []string{"[build failed]", "[setup failed]"}) {
+				addFailed(event.Package)
+				if len(stray) == 0 && packageOutput[event.Package].Len() == 0 {
+					buf.WriteString(event.Output)
+				}
+			}
+		}
+	}
+	if len(failed) == 0 && (exitCode == 0 || testFailed) {
+		return nil
+	}
+	for pkg, strayOutput := range getStrayPackageOutput(stray) {
+		addFailed(pkg)
+		packageOutput[pkg].WriteString(strayOutput)
+	}
+	buf.WriteString(strings.Join(stray, "\n"))
+	err := &BuildError{Output: strings.TrimSpace(buf.String()), Packages: make(map[string]string)}
+	if err.Output == "" {
+		err.Output = string(output)
+	}
+	for _, pkg := range failed {
+		err.Packages[pkg] = strings.TrimSpace(packageOutput[pkg].String())
+	}
+	return err
+}
+
+// getStrayPackageOutput attributes non-JSON compiler output to packages using the "# package" header lines
+func getStrayPackageOutput(stray []string) map[string]string {
+	outputs := make(map[string]string)
+	var pkg string
+	for _, line := range stray {
+		if strings.HasPrefix(line, "# ") {
+			pkg = ""
+			if fields := strings.Fields(getBuildPackage(line[2:])); len(fields) != 0 {
+				pkg = fields[len(fields)-1]
+			}
+			continue
+		}
+		if pkg != "" && !hasAnyPrefix(line, []string{"FAIL", "ok "}) {
+			outputs[pkg] += line + "\n"
+		}
+	}
+	return outputs
+}
+
+// getBuildPackage strips the test variant from an import path like "pkg [pkg.test]"
+func getBuildPackage(importPath string) string {
+	if i := strings.Index(importPath, " ["); i != -1 {
+		return importPath[:i]
+	}
+	return importPath
 }
 
 func groupTestEvents(events []testEvent) []TestStatus {
@@ -178,7 +428,24 @@ func getGroupedTestEvent(events []testEvent) *TestStatus {
 			buf.WriteString("\n")
 		}
 	}
-	return &TestStatus{Elapsed: elapsed, TestResult: testResult, Package: pkg, Test: test, Output: strings.TrimSpace(buf.String())}
+	status := &TestStatus{Elapsed: elapsed, TestResult: testResult, Package: pkg, Test: test, Output: strings.TrimSpace(buf.String())}
+	if testResult == "skip" && test != "" {
+		status.SkipReason = getSkipReason(status.Output)
+	}
+	return status
+}
+
+var logPrefix = regexp.MustCompile(`^\S+\.go:\d+: ?`)
+
+// getSkipReason returns the message passed to t.Skip without the file and line number prefix
+func getSkipReason(output string) string {
+	reasons := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(logPrefix.ReplaceAllString(line, "")); line != "" {
+			reasons = append(reasons, line)
+		}
+	}
+	return strings.Join(reasons, " ")
 }
 
 func parseTestEventLine(line []byte) (*testEvent, bool) {
@@ -196,6 +463,7 @@ func getCoverage(output []byte) []FunctionCoverage {
 		line := scanner.Text()
 		filename, lineNumber, funcName, funcPercent := parseCoverageLine(line)
 		results = append(results, FunctionCoverage{
+			Path:            getCoveragePath(line),
 			Filename:        filename,
 			Function:        funcName,
 			LineNumber:      lineNumber,
@@ -205,6 +473,15 @@ func getCoverage(output []byte) []FunctionCoverage {
 	return results
 }
 
+// getCoveragePath returns the import path of the file from a go tool cover -func line
+func getCoveragePath(line string) string {
+	items := strings.Split(line, ":")
+	if len(items) != 3 {
+		return ""
+	}
+	return strings.TrimSpace(items[0])
+}
+
 func parseCoverageLine(line string) (string, int, string, float32) {
 	var lineNumber int
 	items := strings.Split(line, ":")
@@ -1,12 +1,16 @@
 package autotest
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
 )
 
 var testOutput = `{"Time":"2019-09-25T18:24:29.864601Z","Action":"run","Package":"github.com/robarchibald/autotest/cmd","Test":"TestHi"}
@@ -18,13 +22,45 @@ var testOutput = `{"Time":"2019-09-25T18:24:29.864601Z","Action":"run","Package"
 {"Time":"2019-09-25T18:24:29.865105Z","Action":"pass","Package":"github.com/robarchibald/autotest/cmd","Elapsed":0.110}`
 
 func TestRunTests(t *testing.T) {
-	os.Mkdir("testdata", 0755)
+	tempDir := t.TempDir()
 	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
-	RunTests("folder", "testdata")
+	result := RunTests("folder", RunOptions{TempDir: tempDir})
+	assert.Nil(t, result.Error)
+	assert.Equal(t, filepath.Join(tempDir, "folder", result.Artifacts.RunID, "cover.out"), result.Artifacts.Profile)
+	assert.FileExists(t, result.Artifacts.Log)
+	assert.FileExists(t, result.Artifacts.Stderr)
+	assert.FileExists(t, result.Artifacts.Timing)
+
 	exec = execfactory.NewMockCreator([]execfactory.MockInstance{
-		{SimpleOutputOut: []byte("test"), SimpleOutputExitCode: 2},
+		{RunErr: errors.New("go not found")},
 	})
-	RunTests("folder", "testdata")
+	result = RunTests("folder", RunOptions{TempDir: tempDir})
+	assert.Equal(t, "go not found", result.Error.Error())
+	stderr, _ := os.ReadFile(result.Artifacts.Stderr)
+	assert.Equal(t, "go not found", string(stderr))
+}
+
+func TestRunTestsSnapshot(t *testing.T) {
+	folder := t.TempDir()
+	writeFiles(t, folder, map[string]string{"a_test.go": "package a\n"})
+	defer delete(folderSnapshots, folder)
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	RunTests(folder, RunOptions{TempDir: t.TempDir()})
+	assert.NotContains(t, folderSnapshots, folder, "test files are only read when changed tests are selected")
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{{RunErr: errors.New("build failed")}})
+	RunTests(folder, RunOptions{TempDir: t.TempDir(), ChangedTests: true})
+	assert.NotContains(t, folderSnapshots, folder, "runs which didn't build aren't compared with")
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	RunTests(folder, RunOptions{TempDir: t.TempDir(), ChangedTests: true})
+	assert.Contains(t, folderSnapshots, folder)
+}
+
+func TestRunGoToolOutput(t *testing.T) {
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{{RunErr: errors.New("failed")}})
+	stdout, stderr, code := runGoToolOutput("folder", nil)
+	assert.Equal(t, -1, code)
+	assert.Equal(t, "", string(stdout))
+	assert.Equal(t, "failed", string(stderr))
 }
 
 func TestRunGoTool(t *testing.T) {
@@ -37,12 +73,75 @@ func TestRunGoTool(t *testing.T) {
 }
 
 func TestGetTestEvents(t *testing.T) {
-	if events, _ := getTestEvents([]byte(testOutput)); len(events) != 2 || events[0].Package != "github.com/robarchibald/autotest/cmd" || events[0].Test != "TestHi" || events[1].Package != "github.com/robarchibald/autotest/cmd" || events[1].Test != "" {
+	if events, _ := getTestEvents([]byte(testOutput), 0); len(events) != 2 || events[0].Package != "github.com/robarchibald/autotest/cmd" || events[0].Test != "TestHi" || events[1].Package != "github.com/robarchibald/autotest/cmd" || events[1].Test != "" {
 		t.Error("expected to have parsed 2 lines", events)
 	}
-	if _, err := getTestEvents([]byte(buildFailure)); err == nil || err.Error() != buildFailure {
+	if _, err := getTestEvents([]byte(buildFailure), 2); err == nil || err.Error() != buildFailure {
 		t.Error("expected to error matching failure", err)
 	}
+	if _, err := getTestEvents([]byte(testOutput), 1); err == nil || err.Error() != testOutput {
+		t.Error("expected exit code without a failed test to be a build failure", err)
+	}
+}
+
+var buildFailedOutput = `{"Time":"2019-09-25T18:24:29.865004Z","Action":"output","Package":"github.com/robarchibald/autotest","Output":"FAIL\tgithub.com/robarchibald/autotest [build failed]\n"}
+{"Time":"2019-09-25T18:24:29.865105Z","Action":"fail","Package":"github.com/robarchibald/autotest","Elapsed":0}`
+
+var buildEventOutput = `{"ImportPath":"github.com/robarchibald/autotest","Action":"build-output","Output":"# github.com/robarchibald/autotest\n"}
+{"ImportPath":"github.com/robarchibald/autotest","Action":"build-output","Output":"./console.go:66:2: undefined: x\n"}
+{"ImportPath":"github.com/robarchibald/autotest","Action":"build-fail"}
+{"Time":"2019-09-25T18:24:29.865105Z","Action":"fail","Package":"github.com/robarchibald/autotest","Elapsed":0,"FailedBuild":"github.com/robarchibald/autotest"}`
+
+func TestGetBuildError(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		stray        []string
+		exitCode     int
+		wantOutput   string
+		wantPackages map[string]string
+	}{
+		{name: "Success", output: testOutput},
+		{name: "Build failed output", output: buildFailedOutput, exitCode: 1,
+			wantOutput: "FAIL\tgithub.com/robarchibald/autotest [build failed]", wantPackages: map[string]string{"github.com/robarchibald/autotest": ""}},
+		{name: "Build events", output: buildEventOutput, exitCode: 1,
+			wantOutput: "# github.com/robarchibald/autotest\n./console.go:66:2: undefined: x", wantPackages: map[string]string{"github.com/robarchibald/autotest": "# github.com/robarchibald/autotest\n./console.go:66:2: undefined: x"}},
+		{name: "Stray compiler output", output: buildFailure, stray: strings.Split(buildFailure, "\n"), exitCode: 2,
+			wantOutput: buildFailure, wantPackages: map[string]string{"github.com/robarchibald/autotest": "2019/10/08 17:51:44 cover: autotest/console.go: autotest/console.go:66:2: expected ';', found x (and 2 more errors)"}},
+		{name: "Exit code without failure", output: testOutput, exitCode: 1, wantOutput: testOutput, wantPackages: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, _ := parseTestEvents([]byte(tt.output))
+			err := getBuildError([]byte(tt.output), events, tt.stray, tt.exitCode)
+			if tt.wantPackages == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.Equal(t, tt.wantOutput, err.Error())
+			assert.Equal(t, tt.wantPackages, err.Packages)
+		})
+	}
+}
+
+func TestGetTestEventsStrayLines(t *testing.T) {
+	events, err := getTestEvents([]byte("go: downloading github.com/some/module v1.0.0\n"+testOutput), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+
+	events, err = getTestEvents([]byte(buildEventOutput), 1)
+	assert.Equal(t, []TestStatus{{Package: "github.com/robarchibald/autotest", TestResult: "fail"}}, events)
+	assert.Equal(t, "# github.com/robarchibald/autotest\n./console.go:66:2: undefined: x", err.Error())
+}
+
+func TestGetStrayPackageOutput(t *testing.T) {
+	stray := []string{"# ", "ignored", "# github.com/org/pkg [github.com/org/pkg.test]", "./pkg.go:1:1: undefined: x", "FAIL\tgithub.com/org/pkg [build failed]"}
+	assert.Equal(t, map[string]string{"github.com/org/pkg": "./pkg.go:1:1: undefined: x\n"}, getStrayPackageOutput(stray))
+}
+
+func TestGetBuildPackage(t *testing.T) {
+	assert.Equal(t, "github.com/org/pkg", getBuildPackage("github.com/org/pkg [github.com/org/pkg.test]"))
+	assert.Equal(t, "github.com/org/pkg", getBuildPackage("github.com/org/pkg"))
 }
 
 func TestParseTestEventLine(t *testing.T) {
@@ -67,6 +166,11 @@ func TestParseTestEventLine(t *testing.T) {
 			`{"Time":"2019-09-25T18:24:00.000000Z","Action":"pass","Package":"github.com/robarchibald/autotest/cmd","Test":"TestHi","Elapsed":10}`,
 			&testEvent{Time: time.Date(2019, 9, 25, 18, 24, 0, 0, time.UTC), Elapsed: 10, Action: "pass", Package: "github.com/robarchibald/autotest/cmd", Test: "TestHi"}, true,
 		},
+		{
+			"Build fail action",
+			`{"ImportPath":"github.com/robarchibald/autotest [github.com/robarchibald/autotest.test]","Action":"build-fail"}`,
+			&testEvent{Action: "build-fail", ImportPath: "github.com/robarchibald/autotest [github.com/robarchibald/autotest.test]"}, true,
+		},
 		{"Bare line", "bare line", &testEvent{}, false},
 		{"Bogus JSON", `{{}`, &testEvent{}, false},
 	}
@@ -134,3 +238,29 @@ func TestParseNameAndPercent(t *testing.T) {
 		t.Error("Expected correct values", name, percent)
 	}
 }
+
+func TestRunRegexForTests(t *testing.T) {
+	assert.Equal(t, "^(TestA|TestB)$", RunRegexForTests([]string{"TestA", "TestB", "TestA/sub"}))
+	assert.Equal(t, `^(Test\.Dot)$`, RunRegexForTests([]string{"Test.Dot"}))
+}
+
+func TestRunCoverageArgs(t *testing.T) {
+	assert.Equal(t, []string{"test", "-json", "-short", "-coverprofile", "cover.out", "-timeout", "5s", "./pkg"}, runCoverageArgs(RunOptions{}, "cover.out", "./pkg"))
+	assert.Equal(t, []string{"test", "-json", "-short", "-coverprofile", "cover.out", "-timeout", "5s", "-run", "^TestA$", "."}, runCoverageArgs(RunOptions{RunRegex: "^TestA$"}, "cover.out", "."))
+	assert.Equal(t, []string{"test", "-json", "-short", "-coverprofile", "cover.out", "-timeout", "5s", "-coverpkg", "example.com/m/...", "./pkg"}, runCoverageArgs(RunOptions{CoverPkg: true, Module: &Module{Path: "example.com/m"}}, "cover.out", "./pkg"))
+	assert.Equal(t, []string{"test", "-json", "-short", "-coverprofile", "cover.out", "-timeout", "5s", "-covermode", "count", "."}, runCoverageArgs(RunOptions{CoverMode: CoverModeCount}, "cover.out", "."))
+}
+
+func TestGetSkipReason(t *testing.T) {
+	skipOutput := `{"Time":"2019-09-25T18:24:29.864601Z","Action":"run","Package":"pkg","Test":"TestSlow"}
+{"Time":"2019-09-25T18:24:29.864909Z","Action":"output","Package":"pkg","Test":"TestSlow","Output":"=== RUN   TestSlow\n"}
+{"Time":"2019-09-25T18:24:29.864953Z","Action":"output","Package":"pkg","Test":"TestSlow","Output":"    slow_test.go:12: skipping in short mode\n"}
+{"Time":"2019-09-25T18:24:29.864977Z","Action":"output","Package":"pkg","Test":"TestSlow","Output":"--- SKIP: TestSlow (0.00s)\n"}
+{"Time":"2019-09-25T18:24:29.865105Z","Action":"skip","Package":"pkg","Test":"TestSlow","Elapsed":0}`
+	events, err := getTestEvents([]byte(skipOutput), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []TestStatus{{Package: "pkg", Test: "TestSlow", TestResult: "skip", Output: "slow_test.go:12: skipping in short mode", SkipReason: "skipping in short mode"}}, events)
+
+	assert.Equal(t, "needs database and network", getSkipReason("db_test.go:3: needs database\ndb_test.go:4: and network"))
+	assert.Equal(t, "", getSkipReason(""))
+}
@@ -0,0 +1,161 @@
+package autotest
+
+import (
+	"sync"
+)
+
+// Priority determines the order in which queued folders are tested
+type Priority int
+
+const (
+	// PrioritySweep is used for the initial run of every watched folder
+	PrioritySweep Priority = iota
+	// PriorityFailing is used for folders which had failing tests on their last run
+	PriorityFailing
+	// PriorityChanged is used for folders which were just edited
+	PriorityChanged
+)
+
+// Scheduler runs queued folders using a bounded number of concurrent workers. A folder is only
+// ever queued once. Queuing a folder while it is running will cause it to run again once finished
+type Scheduler struct {
+	run      func(folder string)
+	cond     *sync.Cond
+	queue    map[string]*queuedFolder
+	running  map[string]bool
+	rerun    map[string]Priority
+	sequence int
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type queuedFolder struct {
+	Folder   string
+	Priority Priority
+	Sequence int
+}
+
+// NewScheduler creates a scheduler which calls run for each queued folder, with at most workers running at once
+func NewScheduler(workers int, run func(folder string)) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{
+		run:     run,
+		cond:    sync.NewCond(&sync.Mutex{}),
+		queue:   make(map[string]*queuedFolder),
+		running: make(map[string]bool),
+		rerun:   make(map[string]Priority),
+	}
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// Queue adds a folder to the queue. If the folder is already queued, its priority is raised if needed and
+// it is treated as the most recently queued folder
+func (s *Scheduler) Queue(folder string, priority Priority) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	if s.closed {
+		return
+	}
+	if s.running[folder] {
+		if existing, ok := s.rerun[folder]; !ok || priority > existing {
+			s.rerun[folder] = priority
+		}
+		return
+	}
+	s.enqueue(folder, priority)
+	s.cond.Signal()
+}
+
+// Pending returns the number of folders waiting to run
+func (s *Scheduler) Pending() int {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return len(s.queue)
+}
+
+// Close discards any queued folders and waits for running folders to finish
+func (s *Scheduler) Close() {
+	s.cond.L.Lock()
+	s.closed = true
+	s.queue = make(map[string]*queuedFolder)
+	s.cond.L.Unlock()
+	s.cond.Broadcast()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) enqueue(folder string, priority Priority) {
+	s.sequence++
+	if existing, ok := s.queue[folder]; ok {
+		if priority > existing.Priority {
+			existing.Priority = priority
+		}
+		existing.Sequence = s.sequence
+		return
+	}
+	s.queue[folder] = &queuedFolder{Folder: folder, Priority: priority, Sequence: s.sequence}
+}
+
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+	for {
+		folder, ok := s.next()
+		if !ok {
+			return
+		}
+		s.run(folder)
+		s.finish(folder)
+	}
+}
+
+func (s *Scheduler) next() (string, bool) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return "", false
+	}
+	item := nextQueued(s.queue)
+	delete(s.queue, item.Folder)
+	s.running[item.Folder] = true
+	return item.Folder, true
+}
+
+func (s *Scheduler) finish(folder string) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	delete(s.running, folder)
+	if priority, ok := s.rerun[folder]; ok {
+		delete(s.rerun, folder)
+		if !s.closed {
+			s.enqueue(folder, priority)
+			s.cond.Signal()
+		}
+	}
+}
+
+// nextQueued returns the highest priority item. Edited and failing folders are run newest first so the
+// package being worked on gets results soonest. The initial sweep runs in the order it was queued
+func nextQueued(queue map[string]*queuedFolder) *queuedFolder {
+	var best *queuedFolder
+	for _, item := range queue {
+		if best == nil || item.Priority > best.Priority {
+			best = item
+			continue
+		}
+		if item.Priority < best.Priority {
+			continue
+		}
+		if item.Priority == PrioritySweep && item.Sequence < best.Sequence || item.Priority != PrioritySweep && item.Sequence > best.Sequence {
+			best = item
+		}
+	}
+	return best
+}
@@ -0,0 +1,94 @@
+package autotest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan string, 10)
+	s := NewScheduler(1, func(folder string) {
+		if folder == "first" {
+			close(started)
+			<-block
+		}
+		done <- folder
+	})
+	s.Queue("first", PrioritySweep)
+	<-started
+	s.Queue("sweep1", PrioritySweep)
+	s.Queue("sweep2", PrioritySweep)
+	s.Queue("failing", PriorityFailing)
+	s.Queue("changed1", PriorityChanged)
+	s.Queue("changed2", PriorityChanged)
+	s.Queue("sweep1", PrioritySweep) // duplicate
+	assert.Equal(t, 5, s.Pending())
+	close(block)
+
+	order := []string{}
+	for i := 0; i < 6; i++ {
+		order = append(order, <-done)
+	}
+	assert.Equal(t, []string{"first", "changed2", "changed1", "failing", "sweep2", "sweep1"}, order)
+	s.Close()
+}
+
+func TestSchedulerRerunWhileRunning(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 2)
+	done := make(chan string, 10)
+	s := NewScheduler(2, func(folder string) {
+		started <- struct{}{}
+		<-block
+		done <- folder
+	})
+	s.Queue("folder", PrioritySweep)
+	<-started
+	s.Queue("folder", PriorityChanged)
+	s.Queue("folder", PriorityChanged)
+	assert.Equal(t, 0, s.Pending())
+	close(block)
+	assert.Equal(t, "folder", <-done)
+	assert.Equal(t, "folder", <-done)
+	s.Close()
+	assert.Equal(t, 0, len(done))
+}
+
+func TestSchedulerConcurrencyLimit(t *testing.T) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	running, maxRunning := 0, 0
+	s := NewScheduler(3, func(folder string) {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		wg.Done()
+	})
+	wg.Add(20)
+	for i := 0; i < 20; i++ {
+		s.Queue(string(rune('a'+i)), PrioritySweep)
+	}
+	wg.Wait()
+	s.Close()
+	assert.True(t, maxRunning <= 3, maxRunning)
+}
+
+func TestNextQueued(t *testing.T) {
+	queue := map[string]*queuedFolder{
+		"a": {Folder: "a", Priority: PrioritySweep, Sequence: 1},
+		"b": {Folder: "b", Priority: PrioritySweep, Sequence: 2},
+	}
+	assert.Equal(t, "a", nextQueued(queue).Folder)
+	queue["c"] = &queuedFolder{Folder: "c", Priority: PriorityFailing, Sequence: 3}
+	assert.Equal(t, "c", nextQueued(queue).Folder)
+}
@@ -0,0 +1,214 @@
+package autotest
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// go files of each folder as they were at the start of its last full run which built
+var folderSnapshots = make(map[string]map[string]string)
+var snapshotMutex sync.Mutex
+
+// snapshotFolder records the go files of a folder, read at the start of a run which built, for ChangedTests to
+// compare with
+func snapshotFolder(folder string, sources map[string]string) {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return
+	}
+	snapshotMutex.Lock()
+	folderSnapshots[abs] = sources
+	snapshotMutex.Unlock()
+}
+
+// ChangedTests compares the test files of a folder with how they were at the start of its last full run which
+// built and returns the tests, examples and fuzz targets which changed, or call a test helper or use a declaration
+// which changed, along with the tests which failed in the folder's last run so they keep running until they pass.
+// False is returned when the whole package should be run: when a non-test file changed, a test file doesn't
+// parse, TestMain or init changed, or no test was affected
+func ChangedTests(folder string) ([]string, bool) {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, false
+	}
+	snapshotMutex.Lock()
+	old, ok := folderSnapshots[abs]
+	snapshotMutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	_, current, err := readFolderSources(abs)
+	if err != nil {
+		return nil, false
+	}
+	tests, ok := getImpactedTests(old, current)
+	if !ok {
+		return nil, false
+	}
+	for _, failing := range FailingTests(folder) {
+		tests = appendUnique(tests, failing)
+	}
+	return tests, true
+}
+
+func getImpactedTests(old, current map[string]string) ([]string, bool) {
+	for _, files := range []map[string]string{old, current} {
+		for name := range files {
+			if !strings.HasSuffix(name, "_test.go") && old[name] != current[name] {
+				return nil, false
+			}
+		}
+	}
+	oldDecls, _, _, ok := parseTestDecls(old)
+	if !ok {
+		return nil, false
+	}
+	newDecls, refs, tests, ok := parseTestDecls(current)
+	if !ok {
+		return nil, false
+	}
+
+	impacted := make(map[string]bool)
+	for _, decls := range []map[string]string{oldDecls, newDecls} {
+		for name := range decls {
+			if oldDecls[name] != newDecls[name] {
+				impacted[name] = true
+			}
+		}
+	}
+	if impacted["init"] || impacted["TestMain"] {
+		return nil, false
+	}
+	for changed := true; changed; { // declarations using an impacted declaration are impacted too
+		changed = false
+		for name, uses := range refs {
+			if impacted[name] {
+				continue
+			}
+			for _, use := range uses {
+				if impacted[use] {
+					impacted[name], changed = true, true
+					break
+				}
+			}
+		}
+	}
+
+	selected := []string{}
+	for _, test := range tests {
+		if impacted[test] {
+			selected = append(selected, test)
+		}
+	}
+	sort.Strings(selected)
+	return selected, len(selected) != 0
+}
+
+// parseTestDecls parses the test files and returns the source of each top level declaration without comments, the
+// identifiers used by each declaration and the names of the test functions. Declarations with the same name, like
+// methods on different types, are combined. Examples keep their comments, which hold the expected output
+func parseTestDecls(files map[string]string) (map[string]string, map[string][]string, []string, bool) {
+	decls := make(map[string]string)
+	refs := make(map[string][]string)
+	tests := []string{}
+	fset := token.NewFileSet()
+	for name, source := range files {
+		if !strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, source, parser.ParseComments)
+		if err != nil {
+			return nil, nil, nil, false
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				name := decl.Name.Name
+				if strings.HasPrefix(name, "Example") {
+					decls[name] += source[fset.Position(decl.Pos()).Offset:fset.Position(decl.End()).Offset]
+				} else {
+					decls[name] += printNode(decl)
+				}
+				refs[name] = append(refs[name], getIdentifiers(decl)...)
+				if decl.Recv == nil && isTestName(name) {
+					tests = append(tests, name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					for _, name := range getSpecNames(spec) {
+						decls[name] += printNode(spec)
+						refs[name] = append(refs[name], getIdentifiers(spec)...)
+					}
+				}
+			}
+		}
+	}
+	return decls, refs, tests, true
+}
+
+// printNode prints a node without its comments or original line breaks, so only changes to the code itself show
+func printNode(node ast.Node) string {
+	switch n := node.(type) { // doc comments are printed with the node they belong to
+	case *ast.FuncDecl:
+		stripped := *n
+		stripped.Doc, node = nil, &stripped
+	case *ast.TypeSpec:
+		stripped := *n
+		stripped.Doc, stripped.Comment, node = nil, nil, &stripped
+	case *ast.ValueSpec:
+		stripped := *n
+		stripped.Doc, stripped.Comment, node = nil, nil, &stripped
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), node)
+	return buf.String()
+}
+
+func getSpecNames(spec ast.Spec) []string {
+	names := []string{}
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		names = append(names, spec.Name.Name)
+	case *ast.ValueSpec:
+		for _, name := range spec.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+func getIdentifiers(node ast.Node) []string {
+	names := []string{}
+	ast.Inspect(node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			names = append(names, ident.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// isTestName returns true for the names go test runs: TestXxx, ExampleXxx and FuzzXxx, where Xxx doesn't start
+// with a lower case letter. TestMain is excluded
+func isTestName(name string) bool {
+	if name == "TestMain" {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Example", "Fuzz"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(name[len(prefix):])
+		return len(name) == len(prefix) || !unicode.IsLower(next)
+	}
+	return false
+}
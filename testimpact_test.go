@@ -0,0 +1,108 @@
+package autotest
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var impactTests = map[string]string{
+	"calc.go": "package calc\n\nfunc Add(a, b int) int { return a + b }\n",
+	"calc_test.go": `package calc
+
+import "testing"
+
+var cases = []int{1, 2}
+
+type helper struct{}
+
+func (helper) check(t *testing.T, got, want int) {
+	if got != want {
+		t.Fail()
+	}
+}
+
+func assertSum(t *testing.T, a, b, want int) {
+	helper{}.check(t, Add(a, b), want)
+}
+
+func TestAdd(t *testing.T) {
+	assertSum(t, 1, 2, 3)
+}
+
+func TestCases(t *testing.T) {
+	for _, c := range cases {
+		Add(c, c)
+	}
+}
+
+func TestOther(t *testing.T) {}
+
+func Example() {
+	println(Add(1, 2))
+	// Output: 3
+}
+`,
+}
+
+func changeTestFile(old, new string) map[string]string {
+	return map[string]string{"calc.go": impactTests["calc.go"], "calc_test.go": strings.Replace(impactTests["calc_test.go"], old, new, 1)}
+}
+
+func TestGetImpactedTests(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  map[string]string
+		expected []string
+		ok       bool
+	}{
+		{"unchanged", impactTests, nil, false},
+		{"changed test", changeTestFile("assertSum(t, 1, 2, 3)", "assertSum(t, 2, 2, 4)"), []string{"TestAdd"}, true},
+		{"comments and formatting", changeTestFile("func TestOther(t *testing.T) {}", "// TestOther does nothing\nfunc TestOther(t *testing.T) {\n}"), nil, false},
+		{"helper", changeTestFile("helper{}.check(t, Add(a, b), want)", "helper{}.check(t, Add(b, a), want)"), []string{"TestAdd"}, true},
+		{"method of helper", changeTestFile("if got != want {", "if got-want != 0 {"), []string{"TestAdd"}, true},
+		{"variable", changeTestFile("[]int{1, 2}", "[]int{1, 2, 3}"), []string{"TestCases"}, true},
+		{"new test", changeTestFile("func TestOther(", "func TestNew(t *testing.T) {}\n\nfunc TestOther("), []string{"TestNew"}, true},
+		{"example output", changeTestFile("// Output: 3", "// Output: 4"), []string{"Example"}, true},
+		{"TestMain", changeTestFile("func TestOther(", "func TestMain(m *testing.M) {}\n\nfunc TestOther("), nil, false},
+		{"syntax error", changeTestFile("func TestOther(", "func TestOther(("), nil, false},
+		{"non-test file", map[string]string{"calc.go": impactTests["calc.go"] + "\n", "calc_test.go": impactTests["calc_test.go"]}, nil, false},
+		{"removed file", map[string]string{"calc_test.go": impactTests["calc_test.go"]}, nil, false},
+	}
+	for _, test := range tests {
+		selected, ok := getImpactedTests(impactTests, test.current)
+		assert.Equal(t, test.ok, ok, test.name)
+		if test.ok {
+			assert.Equal(t, test.expected, selected, test.name)
+		}
+	}
+}
+
+func TestChangedTests(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, impactTests)
+	_, ok := ChangedTests(dir)
+	assert.False(t, ok, "no snapshot")
+
+	_, sources, err := readFolderSources(dir)
+	require.NoError(t, err)
+	snapshotFolder(dir, sources)
+	writeFiles(t, dir, changeTestFile("func TestOther(t *testing.T) {}", "func TestOther(t *testing.T) { t.Log() }"))
+	tests, ok := ChangedTests(filepath.Join(dir, "."))
+	assert.True(t, ok)
+	assert.Equal(t, []string{"TestOther"}, tests)
+
+	defer resetTracking()
+	saveFolderResults(&TestResult{Folder: dir, Status: []TestStatus{{Test: "TestStillFailing", TestResult: "fail"}, {Test: "TestOther", TestResult: "fail"}}})
+	tests, _ = ChangedTests(dir)
+	assert.Equal(t, []string{"TestOther", "TestStillFailing"}, tests, "failing tests are run until they pass")
+}
+
+func TestIsTestName(t *testing.T) {
+	for name, expected := range map[string]bool{"Test": true, "TestA": true, "Test_a": true, "Testify": false, "TestMain": false, "Example": true, "ExampleAdd": true, "FuzzParse": true, "Benchmark": false, "helper": false} {
+		assert.Equal(t, expected, isTestName(name), name)
+	}
+}
@@ -0,0 +1,442 @@
+package autotest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TestIndex records the blocks of code run by each test, so the tests covering a function can be looked up. Each
+// test is run on its own to find its coverage. Packages are only indexed again when their files or the files of
+// the packages they depend on in the module change
+type TestIndex struct {
+	Filename string                   `json:"-"`
+	Packages map[string]*PackageIndex // keyed by absolute folder
+
+	mutex sync.RWMutex
+}
+
+// PackageIndex contains the coverage of each test of a package
+type PackageIndex struct {
+	ImportPath   string
+	Hash         string                    // hash of the go files in the folder when it was indexed
+	Dependencies []string                  // folders of the packages in the module imported by the package or its tests
+	DepsHash     string                    // hash of the go files in Dependencies when the folder was indexed
+	Filenames    map[string]string         // location on disk of each covered file
+	Tests        map[string][]ProfileBlock // blocks run by each test
+
+	sources map[string]string // go files in the folder when it was indexed, when they are known
+}
+
+// DefaultIndexFile returns the file in the user's cache folder that the index of the module or workspace in root
+// is stored in
+func DefaultIndexFile(root string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(abs))
+	return filepath.Join(cacheDir, "autotest", filepath.Base(abs)+"-"+hex.EncodeToString(hash[:6])+".json"), nil
+}
+
+// LoadTestIndex reads the index from filename. A missing file gives an empty index
+func LoadTestIndex(filename string) (*TestIndex, error) {
+	index := &TestIndex{Filename: filename, Packages: make(map[string]*PackageIndex)}
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("invalid test index %s: %w", filename, err)
+	}
+	for folder, pkg := range index.Packages { // unchanged folders show what the indexed files looked like
+		if hash, sources, err := readFolderSources(folder); err == nil && hash == pkg.Hash {
+			pkg.sources = sources
+		}
+	}
+	return index, nil
+}
+
+func (idx *TestIndex) save() error {
+	idx.mutex.RLock()
+	content, err := json.Marshal(idx)
+	idx.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.Filename), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(idx.Filename, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+// IndexFolder runs every test of a folder on its own and records the blocks each one covers. Nothing is run when
+// neither the folder's files nor those of its dependencies have changed since it was last indexed
+func (idx *TestIndex) IndexFolder(folder string, options RunOptions) error {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return err
+	}
+	hash, sources, err := readFolderSources(abs)
+	if err != nil {
+		return err
+	}
+	idx.mutex.RLock()
+	indexed, ok := idx.Packages[abs]
+	idx.mutex.RUnlock()
+	if ok && indexed.Hash == hash && hashFolders(indexed.Dependencies) == indexed.DepsHash {
+		return nil
+	}
+
+	dir, pkg := abs, "."
+	if options.Module != nil {
+		dir, pkg = options.Module.Dir, options.Module.PackageArg(abs)
+	}
+	tests, err := listTests(dir, pkg)
+	if err != nil {
+		return err
+	}
+	importPath, dependencies := listDependencies(dir, pkg, abs)
+	tempDir := filepath.Join(options.TempDir, "index", getPackageKey(folder))
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	filter := newCoverageFilter(options.Module, abs, options)
+	index := &PackageIndex{ImportPath: importPath, Hash: hash, Dependencies: dependencies, DepsHash: hashFolders(dependencies),
+		Filenames: make(map[string]string), Tests: make(map[string][]ProfileBlock), sources: sources}
+	for i, test := range tests {
+		profileFile := filepath.Join(tempDir, fmt.Sprintf("test%d.out", i))
+		args := []string{"test", "-short", "-coverprofile", profileFile, "-timeout", "5s", "-run", RunRegexForTests([]string{test})}
+		if options.CoverPkg { // record the blocks the test runs in other packages too
+			args = append(args, "-coverpkg", getCoverPkgArg(options.Module))
+		}
+		args = append(args, pkg)
+		runGoTool(dir, args)
+		profile, err := readProfile(profileFile)
+		if err != nil { // the test didn't build or was stopped before writing a profile
+			continue
+		}
+		profile.resolveFilenames(options.Module, abs)
+		covered := []ProfileBlock{}
+		for _, block := range filter.includedBlocks(profile.Blocks) {
+			if block.Count > 0 {
+				covered = append(covered, block)
+				index.Filenames[block.File] = profile.Filenames[block.File]
+			}
+		}
+		index.Tests[test] = covered
+	}
+
+	idx.mutex.Lock()
+	idx.Packages[abs] = index
+	idx.mutex.Unlock()
+	return idx.save()
+}
+
+// listTests returns the names of the top level tests in a package
+func listTests(dir, pkg string) ([]string, error) {
+	out, exitCode := runGoTool(dir, []string{"test", "-list", "^Test", pkg})
+	if exitCode != 0 {
+		return nil, fmt.Errorf("unable to list tests: %s", strings.TrimSpace(string(out)))
+	}
+	tests := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); strings.HasPrefix(line, "Test") {
+			tests = append(tests, line)
+		}
+	}
+	return tests, nil
+}
+
+// listDependencies returns the import path of the package in folder along with the folders of the packages of the
+// main modules which it or its tests import
+func listDependencies(dir, pkg, folder string) (string, []string) {
+	var importPath string
+	seen := map[string]bool{folder: true}
+	dependencies := []string{}
+	for _, listed := range listPackages(dir, "-deps", "-test", pkg) {
+		switch {
+		case listed.Dir == folder && !strings.Contains(listed.ImportPath, " ") && !strings.HasSuffix(listed.ImportPath, ".test"):
+			importPath = listed.ImportPath
+		case !listed.Standard && listed.Module != nil && listed.Module.Main && !seen[listed.Dir]:
+			seen[listed.Dir] = true
+			dependencies = append(dependencies, listed.Dir)
+		}
+	}
+	sort.Strings(dependencies)
+	return importPath, dependencies
+}
+
+// hashFolders combines the hashes of several folders. Folders which can't be read are hashed as empty
+func hashFolders(folders []string) string {
+	hash := sha256.New()
+	for _, folder := range folders {
+		folderHash, _ := hashFolder(folder)
+		fmt.Fprintf(hash, "%s %s\n", folder, folderHash)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Dependents returns the indexed folders which depend on folder, so they can be indexed again after it changes
+func (idx *TestIndex) Dependents(folder string) []string {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return nil
+	}
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	dependents := []string{}
+	for dir, pkg := range idx.Packages {
+		for _, dependency := range pkg.Dependencies {
+			if dependency == abs {
+				dependents = append(dependents, dir)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// hashFolder hashes the names and contents of the go files in a folder
+func hashFolder(folder string) (string, error) {
+	hash, _, err := readFolderSources(folder)
+	return hash, err
+}
+
+// readFolderSources returns the go files in a folder by name along with a hash of their names and contents
+func readFolderSources(folder string) (string, map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(folder, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(files)
+	hash := sha256.New()
+	sources := make(map[string]string)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(hash, "%s %d\n", filepath.Base(file), len(content))
+		hash.Write(content)
+		sources[filepath.Base(file)] = string(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), sources, nil
+}
+
+// TestsForChanges returns the tests covering the lines of the folder's go files which changed since it was
+// indexed. False is returned when the tests can't be selected from the index: when the folder isn't indexed, a
+// file was added, removed or is a test file, or when no test covers the changes
+func (idx *TestIndex) TestsForChanges(folder string) ([]string, bool) {
+	abs, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, false
+	}
+	idx.mutex.RLock()
+	indexed, ok := idx.Packages[abs]
+	idx.mutex.RUnlock()
+	if !ok || indexed.sources == nil {
+		return nil, false
+	}
+	_, current, err := readFolderSources(abs)
+	if err != nil || len(current) != len(indexed.sources) {
+		return nil, false
+	}
+
+	selected := make(map[string]bool)
+	for name, content := range current {
+		old, ok := indexed.sources[name]
+		switch {
+		case !ok || strings.HasSuffix(name, "_test.go") && old != content:
+			return nil, false
+		case old == content:
+			continue
+		}
+		lines := getChangedOldLines(strings.Split(old, "\n"), strings.Split(content, "\n"))
+		for _, line := range lines {
+			for _, test := range idx.coveringTests(filepath.Join(abs, name), line, line) {
+				if test.folder == abs { // only the folder's own tests can be selected for its run
+					selected[test.name] = true
+				}
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, false
+	}
+	tests := make([]string, 0, len(selected))
+	for test := range selected {
+		tests = append(tests, test)
+	}
+	sort.Strings(tests)
+	return tests, true
+}
+
+// getChangedOldLines returns the line numbers in old which were changed or removed, along with the lines either
+// side of where new lines were inserted
+func getChangedOldLines(old, new []string) []int {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	changed := []int{}
+	line := prefix + 1 // the next line of old
+	replacing := false // added lines replace the removed lines before them
+	for _, item := range getDiff(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix]) {
+		switch item.Kind {
+		case diffEqual:
+			line++
+			replacing = false
+		case diffRemoved:
+			changed = append(changed, line)
+			line++
+			replacing = true
+		case diffAdded:
+			if replacing {
+				continue
+			}
+			if line > 1 {
+				changed = append(changed, line-1)
+			}
+			if line <= len(old) {
+				changed = append(changed, line)
+			}
+		}
+	}
+	sort.Ints(changed)
+	unique := []int{}
+	for i, line := range changed {
+		if i == 0 || line != changed[i-1] {
+			unique = append(unique, line)
+		}
+	}
+	return unique
+}
+
+type indexedTest struct {
+	folder     string
+	importPath string
+	name       string
+}
+
+// qualifiedName returns the test name prefixed with its package, e.g. example.com/m/calc.TestAdd
+func (t indexedTest) qualifiedName() string {
+	if t.importPath == "" {
+		return t.name
+	}
+	return t.importPath + "." + t.name
+}
+
+// CoveringTests returns the tests which run any of the lines from startLine to endLine of a file, qualified by
+// their package. The file is either an import path, like those in coverage profiles, or a file name on disk
+func (idx *TestIndex) CoveringTests(file string, startLine, endLine int) []string {
+	tests := []string{}
+	for _, test := range idx.coveringTests(file, startLine, endLine) {
+		tests = append(tests, test.qualifiedName())
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+func (idx *TestIndex) coveringTests(file string, startLine, endLine int) []indexedTest {
+	abs, _ := filepath.Abs(file)
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	tests := []indexedTest{}
+	for folder, pkg := range idx.Packages {
+		for test, blocks := range pkg.Tests {
+			for _, block := range blocks {
+				if (block.File == file || pkg.Filenames[block.File] == abs) && block.StartLine <= endLine && block.EndLine >= startLine {
+					tests = append(tests, indexedTest{folder: folder, importPath: pkg.ImportPath, name: test})
+					break
+				}
+			}
+		}
+	}
+	return tests
+}
+
+// Annotate sets the tests covering each function of a result. Tests of other packages are qualified by their package
+func (idx *TestIndex) Annotate(result *TestResult) {
+	folder, _ := filepath.Abs(result.Folder)
+	extents := make(map[string][]functionExtent)
+	for i, item := range result.Coverage {
+		if item.Path == "" {
+			continue
+		}
+		filename, ok := "", false
+		if result.Profile != nil {
+			filename, ok = result.Profile.Filenames[item.Path]
+		}
+		if !ok {
+			filename = idx.getFilename(item.Path)
+		}
+		if _, ok := extents[filename]; !ok {
+			extents[filename] = getFunctionExtents(filename, func(*ast.FuncDecl) bool { return true })
+		}
+		for _, fn := range extents[filename] {
+			if fn.Name == item.Function && fn.StartLine == item.LineNumber {
+				coveredBy := []string{}
+				for _, test := range idx.coveringTests(item.Path, fn.StartLine, fn.EndLine) {
+					if test.folder == folder {
+						coveredBy = append(coveredBy, test.name)
+					} else {
+						coveredBy = append(coveredBy, test.qualifiedName())
+					}
+				}
+				sort.Strings(coveredBy)
+				result.Coverage[i].CoveredBy = coveredBy
+				break
+			}
+		}
+	}
+}
+
+// FunctionLines returns the lines of a function in a file on disk. Methods may be given with their receiver type,
+// e.g. Type.Method
+func FunctionLines(filename, function string) (int, int, bool) {
+	function = function[strings.LastIndex(function, ".")+1:]
+	for _, fn := range getFunctionExtents(filename, func(fn *ast.FuncDecl) bool { return fn.Name.Name == function }) {
+		return fn.StartLine, fn.EndLine, true
+	}
+	return 0, 0, false
+}
+
+func (idx *TestIndex) getFilename(file string) string {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	for _, pkg := range idx.Packages {
+		if filename, ok := pkg.Filenames[file]; ok {
+			return filename
+		}
+	}
+	return file
+}
@@ -0,0 +1,205 @@
+package autotest
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/EndFirstCorp/execfactory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var indexedModule = map[string]string{
+	"go.mod": "module example.com/m\n\ngo 1.17\n",
+	"calc/calc.go": `package calc
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Sub(a, b int) int {
+	return a - b
+}
+
+func Unused() {
+	println("unused")
+}
+`,
+	"calc/calc_test.go": `package calc
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if Add(1, 2) != 3 {
+		t.Fail()
+	}
+}
+
+func TestBoth(t *testing.T) {
+	if Add(1, 2)-Sub(2, 1) != 2 {
+		t.Fail()
+	}
+}
+`,
+	"api/api_test.go": `package api
+
+import (
+	"testing"
+
+	"example.com/m/calc"
+)
+
+func TestAdd(t *testing.T) {
+	if calc.Add(2, 2) != 4 {
+		t.Fail()
+	}
+}
+`,
+}
+
+func TestIndexFolder(t *testing.T) {
+	exec = execfactory.NewOSCreator()
+	root := t.TempDir()
+	writeFiles(t, root, indexedModule)
+	module := &Module{Path: "example.com/m", Dir: root}
+	folder := filepath.Join(root, "calc")
+	filename := filepath.Join(t.TempDir(), "cache", "index.json")
+
+	index, err := LoadTestIndex(filename)
+	require.NoError(t, err)
+	require.NoError(t, index.IndexFolder(folder, RunOptions{TempDir: t.TempDir(), Module: module}))
+	require.Contains(t, index.Packages, folder)
+	assert.ElementsMatch(t, []string{"TestAdd", "TestBoth"}, keys(index.Packages[folder].Tests))
+
+	calc := filepath.Join(folder, "calc.go")
+	assert.Equal(t, []string{"example.com/m/calc.TestAdd", "example.com/m/calc.TestBoth"}, index.CoveringTests(calc, 3, 5))
+	assert.Equal(t, []string{"example.com/m/calc.TestBoth"}, index.CoveringTests("example.com/m/calc/calc.go", 7, 9))
+	assert.Equal(t, []string{}, index.CoveringTests(calc, 11, 13))
+
+	loaded, err := LoadTestIndex(filename)
+	require.NoError(t, err)
+	assert.Equal(t, index.Packages, loaded.Packages, "the index is saved after each folder")
+
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{})
+	assert.NoError(t, loaded.IndexFolder(folder, RunOptions{TempDir: t.TempDir(), Module: module}), "unchanged folders aren't run again")
+
+	result := &TestResult{Folder: folder, Coverage: []FunctionCoverage{
+		{Path: "example.com/m/calc/calc.go", Filename: "calc.go", Function: "Sub", LineNumber: 7},
+		{Path: "example.com/m/calc/calc.go", Filename: "calc.go", Function: "Unused", LineNumber: 11},
+		{Filename: "total", Function: "(statements)"},
+	}}
+	loaded.Annotate(result)
+	assert.Equal(t, []string{"TestBoth"}, result.Coverage[0].CoveredBy)
+	assert.Equal(t, []string{}, result.Coverage[1].CoveredBy)
+	assert.Nil(t, result.Coverage[2].CoveredBy)
+
+	_, ok := loaded.TestsForChanges(folder)
+	assert.False(t, ok, "nothing changed")
+	writeFiles(t, root, map[string]string{"calc/calc.go": strings.Replace(indexedModule["calc/calc.go"], "return a - b", "return -(b - a)", 1)})
+	tests, ok := loaded.TestsForChanges(folder)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"TestBoth"}, tests)
+	writeFiles(t, root, map[string]string{"calc/calc.go": strings.Replace(indexedModule["calc/calc.go"], "println(\"unused\")", "println(\"still unused\")", 1)})
+	_, ok = loaded.TestsForChanges(folder)
+	assert.False(t, ok, "no test covers the change")
+	writeFiles(t, root, map[string]string{"calc/calc_test.go": indexedModule["calc/calc_test.go"] + "\n// changed\n"})
+	_, ok = loaded.TestsForChanges(folder)
+	assert.False(t, ok, "test files changed")
+	_, ok = loaded.TestsForChanges(root)
+	assert.False(t, ok, "not indexed")
+
+	start, end, ok := FunctionLines(calc, "Sub")
+	assert.True(t, ok)
+	assert.Equal(t, []int{7, 9}, []int{start, end})
+	_, _, ok = FunctionLines(calc, "calc.Missing")
+	assert.False(t, ok)
+}
+
+func keys(m map[string][]ProfileBlock) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestLoadTestIndex(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.json")
+	require.NoError(t, os.WriteFile(filename, []byte("{"), 0644))
+	_, err := LoadTestIndex(filename)
+	assert.Error(t, err)
+
+	root := t.TempDir()
+	defaultFile, err := DefaultIndexFile(root)
+	if err == nil { // there's no cache folder without $HOME
+		cacheDir, _ := os.UserCacheDir()
+		assert.Equal(t, filepath.Join(cacheDir, "autotest"), filepath.Dir(defaultFile))
+		assert.Regexp(t, "^"+regexp.QuoteMeta(filepath.Base(root))+"-[0-9a-f]{12}\\.json$", filepath.Base(defaultFile))
+		other, _ := DefaultIndexFile(filepath.Join(root, "other"))
+		assert.NotEqual(t, defaultFile, other)
+	}
+}
+
+func TestListTests(t *testing.T) {
+	exec = execfactory.NewMockCreator([]execfactory.MockInstance{
+		{SimpleOutputOut: []byte("TestA\nTestB\nok  \texample.com/m\t0.01s\n")},
+		{SimpleOutputOut: []byte("no Go files"), SimpleOutputExitCode: 1},
+	})
+	tests, err := listTests(".", ".")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TestA", "TestB"}, tests)
+	_, err = listTests(".", ".")
+	assert.Error(t, err)
+}
+
+func TestHashFolder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n", "notes.txt": "ignored"})
+	first, err := hashFolder(dir)
+	require.NoError(t, err)
+	writeFiles(t, dir, map[string]string{"notes.txt": "still ignored"})
+	second, _ := hashFolder(dir)
+	assert.Equal(t, first, second)
+	writeFiles(t, dir, map[string]string{"a.go": "package a\n\nfunc A() {}\n"})
+	third, _ := hashFolder(dir)
+	assert.NotEqual(t, first, third)
+}
+
+func TestGetChangedOldLines(t *testing.T) {
+	old := []string{"a", "b", "c", "d", "e"}
+	assert.Equal(t, []int{}, getChangedOldLines(old, old))
+	assert.Equal(t, []int{3}, getChangedOldLines(old, []string{"a", "b", "x", "d", "e"}))
+	assert.Equal(t, []int{2, 3}, getChangedOldLines(old, []string{"a", "b", "x", "c", "d", "e"}), "lines either side of an insert")
+	assert.Equal(t, []int{4, 5}, getChangedOldLines(old, []string{"a", "b", "c"}))
+	assert.Equal(t, []int{1}, getChangedOldLines(old, []string{"x", "a", "b", "c", "d", "e"}))
+	assert.Equal(t, []int{5}, getChangedOldLines(old, []string{"a", "b", "c", "d", "e", "f"}))
+}
+
+func TestIndexFolderDependencies(t *testing.T) {
+	exec = execfactory.NewOSCreator()
+	root := t.TempDir()
+	writeFiles(t, root, indexedModule)
+	module := &Module{Path: "example.com/m", Dir: root}
+	calcFolder, apiFolder := filepath.Join(root, "calc"), filepath.Join(root, "api")
+	index, err := LoadTestIndex(filepath.Join(t.TempDir(), "index.json"))
+	require.NoError(t, err)
+	require.NoError(t, index.IndexFolder(calcFolder, RunOptions{TempDir: t.TempDir(), Module: module, CoverPkg: true}))
+	require.NoError(t, index.IndexFolder(apiFolder, RunOptions{TempDir: t.TempDir(), Module: module, CoverPkg: true}))
+
+	assert.Equal(t, []string{calcFolder}, index.Packages[apiFolder].Dependencies)
+	assert.Equal(t, []string{apiFolder}, index.Dependents(calcFolder))
+	assert.Equal(t, []string{"example.com/m/api.TestAdd", "example.com/m/calc.TestAdd", "example.com/m/calc.TestBoth"},
+		index.CoveringTests(filepath.Join(calcFolder, "calc.go"), 3, 5), "tests with the same name in different packages")
+
+	result := &TestResult{Folder: calcFolder, Coverage: []FunctionCoverage{{Path: "example.com/m/calc/calc.go", Filename: "calc.go", Function: "Add", LineNumber: 3}}}
+	index.Annotate(result)
+	assert.Equal(t, []string{"TestAdd", "TestBoth", "example.com/m/api.TestAdd"}, result.Coverage[0].CoveredBy)
+
+	writeFiles(t, root, map[string]string{"calc/calc.go": indexedModule["calc/calc.go"] + "\nfunc Mul(a, b int) int {\n\treturn a * b\n}\n"})
+	depsHash := index.Packages[apiFolder].DepsHash
+	require.NoError(t, index.IndexFolder(apiFolder, RunOptions{TempDir: t.TempDir(), Module: module, CoverPkg: true}), "indexed again after a dependency changed")
+	assert.NotEqual(t, depsHash, index.Packages[apiFolder].DepsHash)
+}
@@ -1,30 +1,81 @@
 package autotest
 
 import (
+	"math"
+	"sort"
 	"sync"
 )
 
 var trackedFolders = make(map[string]*tracking)
 var folderMutex sync.RWMutex
 
+// DiffOptions controls which coverage changes are reported
+type DiffOptions struct {
+	AbsoluteTolerance float32 // changes of at most this many percentage points are ignored
+	RelativeTolerance float32 // changes of at most this fraction of the original coverage are ignored
+	DecreasesOnly     bool    // only report functions whose coverage went down
+	OscillationLimit  int     // functions whose coverage changes direction this many times are nondeterministic. 0 disables
+}
+
+// CoverageDiffOptions is used by Track when comparing coverage with the original results
+var CoverageDiffOptions = DiffOptions{OscillationLimit: 3}
+
+type coverageKey struct {
+	Filename   string
+	Function   string
+	LineNumber int
+}
+
 type tracking struct {
 	Original *TestResult
 	Last     *TestResult
+
+	mutex   sync.Mutex                // guards Original, Last and the history
+	history map[coverageKey][]float32 // coverage of each function in every run since the folder's code changed
+	sources string                    // SourceHash of the runs in history
 }
 
-// Track keeps track of initial results and returns changed coverage results
+// Track keeps track of initial results and returns changed coverage results. Initial results which didn't build or
+// measure coverage are replaced by the first results which did, as every function would be new compared with them
 func Track(test *TestResult) *TestResult {
 	saved := getFolderResults(test.Folder)
 	if saved == nil {
 		saveFolderResults(test)
 		return nil
 	}
+	saved.mutex.Lock()
+	if !isComparable(saved.Original) && isComparable(test) {
+		saved.Original = test
+	}
 	diff := getResultDiff(saved, test)
 	saved.Last = test
-	saveTracking(saved)
+	saved.mutex.Unlock()
 	return diff
 }
 
+// SetBaseline sets the results that later results of the folder are compared with
+func SetBaseline(baseline *TestResult) {
+	saved := getFolderResults(baseline.Folder)
+	if saved == nil {
+		saveFolderResults(baseline)
+		return
+	}
+	saved.mutex.Lock()
+	saved.Original = baseline
+	saved.mutex.Unlock()
+}
+
+// isComparable returns true when later results can be compared with a result: a full run which built and measured
+// coverage
+func isComparable(result *TestResult) bool {
+	return result.Error == nil && !result.IsPartial() && len(result.Coverage) != 0
+}
+
+// HasBaseline returns true when results are being tracked for the folder
+func HasBaseline(folder string) bool {
+	return getFolderResults(folder) != nil
+}
+
 func getFolderResults(folder string) *tracking {
 	folderMutex.RLock()
 	saved := trackedFolders[folder]
@@ -32,6 +83,24 @@ func getFolderResults(folder string) *tracking {
 	return saved
 }
 
+// getAllTracking returns the tracking of every folder, keyed by folder
+func getAllTracking() map[string]*tracking {
+	folderMutex.RLock()
+	defer folderMutex.RUnlock()
+	all := make(map[string]*tracking, len(trackedFolders))
+	for folder, v := range trackedFolders {
+		all[folder] = v
+	}
+	return all
+}
+
+// results returns the original and last results, taken under the tracking's mutex as Track replaces them
+func (v *tracking) results() (*TestResult, *TestResult) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.Original, v.Last
+}
+
 func saveTracking(v *tracking) {
 	folderMutex.Lock()
 	trackedFolders[v.Original.Folder] = v
@@ -39,22 +108,51 @@ func saveTracking(v *tracking) {
 }
 
 func saveFolderResults(test *TestResult) {
-	saveTracking(&tracking{Original: test, Last: test})
+	v := &tracking{Original: test, Last: test}
+	v.recordHistory(test)
+	saveTracking(v)
 }
 
 func getResultDiff(v *tracking, current *TestResult) *TestResult {
 	if current.Error != nil {
 		return current
 	}
+	v.recordHistory(current)
 
 	return &TestResult{
-		Folder:   current.Folder,
-		Status:   current.Status,
-		Coverage: getCoverageDiff(v.Original.Coverage, current.Coverage),
+		Folder:     current.Folder,
+		ModulePath: current.ModulePath,
+		Mode:       current.Mode,
+		Status:     current.Status,
+		Coverage:   v.markNondeterministic(getCoverageDiff(v.Original.Coverage, current.Coverage, CoverageDiffOptions), CoverageDiffOptions.OscillationLimit),
+		Profile:    current.Profile,
+		Patch:      current.Patch,
+
+		NewlySkipped: getNewlySkipped(v.Last.Status, current.Status),
+	}
+}
+
+// getNewlySkipped returns the tests which are skipped now but ran in the previous run
+func getNewlySkipped(previous, current []TestStatus) []string {
+	ran := make(map[string]bool)
+	for _, status := range previous {
+		if status.Test != "" && status.TestResult != "skip" {
+			ran[status.Package+"."+status.Test] = true
+		}
+	}
+	skipped := []string{}
+	for _, status := range current {
+		if status.TestResult == "skip" && ran[status.Package+"."+status.Test] {
+			skipped = append(skipped, status.Test)
+		}
 	}
+	return skipped
 }
 
-func getCoverageDiff(first, current []FunctionCoverage) []FunctionCoverage {
+// getCoverageDiff returns the functions whose coverage changed from the first results by more than the
+// tolerance, along with any functions which didn't exist in the first results. Functions are matched by file, name
+// and line. A function whose name is unique in its file is matched by name alone, so moving it isn't a change
+func getCoverageDiff(first, current []FunctionCoverage, options DiffOptions) []FunctionCoverage {
 	type funcLocation struct {
 		Filename string
 		Function string
@@ -70,13 +168,139 @@ func getCoverageDiff(first, current []FunctionCoverage) []FunctionCoverage {
 	}
 	differentCoverage := []FunctionCoverage{}
 	for _, item := range current {
-		if locations, ok := coverageMap[funcLocation{item.Filename, item.Function}]; ok {
-			for _, location := range locations {
-				if location.LineNumber == item.LineNumber && location.Coverage != item.CoveragePercent { // make this able to be slightly off
-					differentCoverage = append(differentCoverage, item)
-				}
+		locations, ok := coverageMap[funcLocation{item.Filename, item.Function}]
+		if !ok {
+			differentCoverage = append(differentCoverage, item)
+			continue
+		}
+		for _, location := range locations {
+			// functions are matched by line unless there's only one function of that name, which may have moved
+			if (location.LineNumber == item.LineNumber || len(locations) == 1) && isCoverageChanged(location.Coverage, item.CoveragePercent, options) {
+				differentCoverage = append(differentCoverage, item)
+				break
 			}
 		}
 	}
 	return differentCoverage
 }
+
+func isCoverageChanged(original, current float32, options DiffOptions) bool {
+	change := current - original
+	if change == 0 || options.DecreasesOnly && change > 0 {
+		return false
+	}
+	absChange := float32(math.Abs(float64(change)))
+	return absChange > options.AbsoluteTolerance && absChange > options.RelativeTolerance*original
+}
+
+// recordHistory adds the coverage of each function to its history. The history is cleared when the code of the
+// folder changed, so only coverage changes between runs of the same code count towards nondeterminism
+func (v *tracking) recordHistory(result *TestResult) {
+	if v.history == nil || result.SourceHash != v.sources {
+		v.history = make(map[coverageKey][]float32)
+		v.sources = result.SourceHash
+	}
+	for _, item := range result.Coverage {
+		key := coverageKey{item.Filename, item.Function, item.LineNumber}
+		v.history[key] = append(v.history[key], item.CoveragePercent)
+	}
+}
+
+// markNondeterministic flags functions whose coverage went up and down at least limit times without the code
+// changing. They are still reported, so real regressions in them aren't hidden
+func (v *tracking) markNondeterministic(coverage []FunctionCoverage, limit int) []FunctionCoverage {
+	if limit <= 0 {
+		return coverage
+	}
+	for i, item := range coverage {
+		key := coverageKey{item.Filename, item.Function, item.LineNumber}
+		coverage[i].Nondeterministic = getDirectionChanges(v.history[key]) >= limit
+	}
+	return coverage
+}
+
+// getDirectionChanges counts how often a series of values switches between going up and going down
+func getDirectionChanges(values []float32) int {
+	changes := 0
+	var lastDirection float32
+	for i := 1; i < len(values); i++ {
+		direction := values[i] - values[i-1]
+		if direction == 0 {
+			continue
+		}
+		if lastDirection != 0 && (direction > 0) != (lastDirection > 0) {
+			changes++
+		}
+		lastDirection = direction
+	}
+	return changes
+}
+
+// FailingFolders returns the folders whose last run failed to build or had failing tests
+func FailingFolders() []string {
+	folders := []string{}
+	for folder, v := range getAllTracking() {
+		if _, last := v.results(); hasFailures(last) {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+func hasFailures(result *TestResult) bool {
+	if result.Error != nil {
+		return true
+	}
+	for _, status := range result.Status {
+		if status.TestResult == "fail" {
+			return true
+		}
+	}
+	return false
+}
+
+// FailingTests returns the names of the failing tests from the last run of a folder
+func FailingTests(folder string) []string {
+	saved := getFolderResults(folder)
+	tests := []string{}
+	if saved == nil {
+		return tests
+	}
+	_, last := saved.results()
+	for _, status := range last.Status {
+		if status.TestResult == "fail" && status.Test != "" {
+			tests = append(tests, status.Test)
+		}
+	}
+	return tests
+}
+
+// LastResults returns the last result of every tracked folder, ordered by folder
+func LastResults() []*TestResult {
+	results := []*TestResult{}
+	for _, v := range getAllTracking() {
+		_, last := v.results()
+		results = append(results, last)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Folder < results[j].Folder })
+	return results
+}
+
+// ResetBaseline makes the last result of every folder the new baseline that later results are compared with.
+// Folders whose last run was partial, failed to build or had no coverage keep their baseline
+func ResetBaseline() {
+	for _, v := range getAllTracking() {
+		v.mutex.Lock()
+		if isComparable(v.Last) {
+			v.Original = v.Last
+		}
+		v.mutex.Unlock()
+	}
+}
+
+// resetTracking forgets the results of every folder
+func resetTracking() {
+	folderMutex.Lock()
+	trackedFolders = make(map[string]*tracking)
+	folderMutex.Unlock()
+}
@@ -1,6 +1,7 @@
 package autotest
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -30,13 +31,144 @@ func TestGetResultDiff(t *testing.T) {
 func TestGetCoverageDiff(t *testing.T) {
 	diff := getCoverageDiff(
 		[]FunctionCoverage{{Function: "1", CoveragePercent: 25}},
-		[]FunctionCoverage{{Function: "1", CoveragePercent: 30}})
+		[]FunctionCoverage{{Function: "1", CoveragePercent: 30}}, DiffOptions{})
 	require.Equal(t, 1, len(diff))
 	assert.Equal(t, float32(30.0), diff[0].CoveragePercent)
 
 	diff = getCoverageDiff(
 		[]FunctionCoverage{},
-		[]FunctionCoverage{{Function: "1", CoveragePercent: 0}})
+		[]FunctionCoverage{{Function: "1", CoveragePercent: 0}}, DiffOptions{})
 	require.Equal(t, 1, len(diff))
-	assert.Equal(t, 0, diff[0].CoveragePercent)
+	assert.Equal(t, float32(0), diff[0].CoveragePercent)
+}
+
+func TestGetCoverageDiffMatching(t *testing.T) {
+	first := []FunctionCoverage{
+		{Filename: "a.go", Function: "String", LineNumber: 3, CoveragePercent: 50},
+		{Filename: "a.go", Function: "String", LineNumber: 9, CoveragePercent: 100},
+		{Filename: "a.go", Function: "Moved", LineNumber: 12, CoveragePercent: 50},
+	}
+	current := []FunctionCoverage{
+		{Filename: "a.go", Function: "String", LineNumber: 3, CoveragePercent: 50},
+		{Filename: "a.go", Function: "String", LineNumber: 9, CoveragePercent: 100},
+		{Filename: "a.go", Function: "Moved", LineNumber: 20, CoveragePercent: 50},
+		{Filename: "a.go", Function: "New", LineNumber: 30, CoveragePercent: 0},
+	}
+	diff := getCoverageDiff(first, current, DiffOptions{})
+	require.Equal(t, 1, len(diff), "functions with a unique name are matched even when they moved")
+	assert.Equal(t, "New", diff[0].Function, "functions missing from the first results are reported")
+}
+
+func TestGetCoverageDiffOptions(t *testing.T) {
+	first := []FunctionCoverage{
+		{Filename: "a.go", Function: "Small", LineNumber: 1, CoveragePercent: 50},
+		{Filename: "a.go", Function: "Up", LineNumber: 5, CoveragePercent: 50},
+		{Filename: "a.go", Function: "Down", LineNumber: 9, CoveragePercent: 50},
+		{Filename: "a.go", Function: "Moved", LineNumber: 12, CoveragePercent: 50},
+	}
+	current := []FunctionCoverage{
+		{Filename: "a.go", Function: "Small", LineNumber: 1, CoveragePercent: 50.5},
+		{Filename: "a.go", Function: "Up", LineNumber: 5, CoveragePercent: 80},
+		{Filename: "a.go", Function: "Down", LineNumber: 9, CoveragePercent: 20},
+		{Filename: "a.go", Function: "Moved", LineNumber: 14, CoveragePercent: 25},
+	}
+	names := func(diff []FunctionCoverage) []string {
+		functions := []string{}
+		for _, item := range diff {
+			functions = append(functions, item.Function)
+		}
+		return functions
+	}
+	assert.Equal(t, []string{"Small", "Up", "Down", "Moved"}, names(getCoverageDiff(first, current, DiffOptions{})))
+	assert.Equal(t, []string{"Up", "Down", "Moved"}, names(getCoverageDiff(first, current, DiffOptions{AbsoluteTolerance: 1})))
+	assert.Equal(t, []string{"Up", "Down"}, names(getCoverageDiff(first, current, DiffOptions{RelativeTolerance: 0.5})))
+	assert.Equal(t, []string{"Down", "Moved"}, names(getCoverageDiff(first, current, DiffOptions{AbsoluteTolerance: 1, DecreasesOnly: true})))
+}
+
+func TestMarkNondeterministic(t *testing.T) {
+	v := &tracking{}
+	item := FunctionCoverage{Filename: "a.go", Function: "Retry", LineNumber: 3}
+	for _, percent := range []float32{50, 75, 50, 75, 50} {
+		item.CoveragePercent = percent
+		v.recordHistory(&TestResult{SourceHash: "v1", Coverage: []FunctionCoverage{item}})
+	}
+	diff := v.markNondeterministic([]FunctionCoverage{item}, 3)
+	require.Equal(t, 1, len(diff))
+	assert.True(t, diff[0].Nondeterministic)
+	assert.True(t, v.markNondeterministic([]FunctionCoverage{item}, 3)[0].Nondeterministic, "still reported on later runs")
+	assert.False(t, v.markNondeterministic([]FunctionCoverage{item}, 0)[0].Nondeterministic)
+
+	v.recordHistory(&TestResult{SourceHash: "v2", Coverage: []FunctionCoverage{item}})
+	assert.False(t, v.markNondeterministic([]FunctionCoverage{item}, 3)[0].Nondeterministic, "history cleared when the code changed")
+}
+
+func TestGetDirectionChanges(t *testing.T) {
+	assert.Equal(t, 0, getDirectionChanges(nil))
+	assert.Equal(t, 0, getDirectionChanges([]float32{1, 2, 2, 3}))
+	assert.Equal(t, 3, getDirectionChanges([]float32{1, 2, 2, 1, 2, 1}))
+}
+
+func TestFailingFoldersAndTests(t *testing.T) {
+	saveFolderResults(&TestResult{Folder: "failing", Status: []TestStatus{{Test: "TestA", TestResult: "fail"}, {Test: "TestB", TestResult: "pass"}, {TestResult: "fail"}}})
+	saveFolderResults(&TestResult{Folder: "passing", Status: []TestStatus{{Test: "TestA", TestResult: "pass"}}})
+	defer resetTracking()
+
+	assert.Equal(t, []string{"failing"}, FailingFolders())
+	assert.Equal(t, []string{"TestA"}, FailingTests("failing"))
+	assert.Equal(t, []string{}, FailingTests("unknown"))
+}
+
+func TestResetBaseline(t *testing.T) {
+	coverage := []FunctionCoverage{{Filename: "a.go", Function: "A", CoveragePercent: 50}}
+	first, last := &TestResult{Folder: "baseline", Coverage: coverage}, &TestResult{Folder: "baseline", Coverage: coverage}
+	saveTracking(&tracking{Original: first, Last: last})
+	focused := &TestResult{Folder: "focused", Coverage: coverage}
+	saveTracking(&tracking{Original: focused, Last: &TestResult{Folder: "focused", Mode: RunModeFocus}})
+	failed := &TestResult{Folder: "failed", Coverage: coverage}
+	saveTracking(&tracking{Original: failed, Last: &TestResult{Folder: "failed", Error: errors.New("build failed")}})
+	defer resetTracking()
+
+	ResetBaseline()
+	assert.Equal(t, last, getFolderResults("baseline").Original)
+	assert.Equal(t, focused, getFolderResults("focused").Original, "partial runs aren't a baseline")
+	assert.Equal(t, failed, getFolderResults("failed").Original, "failed builds aren't a baseline")
+}
+
+func TestTrackReplacesIncomparableBaseline(t *testing.T) {
+	defer resetTracking()
+	Track(&TestResult{Folder: "replaced", Error: errors.New("build failed")})
+	coverage := []FunctionCoverage{{Filename: "a.go", Function: "A", CoveragePercent: 50}, {Filename: "a.go", Function: "B", CoveragePercent: 0}}
+	diff := Track(&TestResult{Folder: "replaced", Coverage: coverage})
+	require.NotNil(t, diff)
+	assert.Equal(t, []FunctionCoverage{}, diff.Coverage, "functions aren't all reported as new")
+
+	Track(&TestResult{Folder: "replaced", Mode: RunModeFocus})
+	diff = Track(&TestResult{Folder: "replaced", Coverage: []FunctionCoverage{coverage[0], {Filename: "a.go", Function: "B", CoveragePercent: 100}}})
+	assert.Equal(t, "B", diff.Coverage[0].Function)
+}
+
+func TestGetNewlySkipped(t *testing.T) {
+	previous := []TestStatus{{Package: "pkg", Test: "TestA", TestResult: "pass"}, {Package: "pkg", Test: "TestB", TestResult: "skip"}, {Package: "pkg", TestResult: "pass"}}
+	current := []TestStatus{{Package: "pkg", Test: "TestA", TestResult: "skip"}, {Package: "pkg", Test: "TestB", TestResult: "skip"}, {Package: "pkg", Test: "TestC", TestResult: "skip"}}
+	assert.Equal(t, []string{"TestA"}, getNewlySkipped(previous, current))
+}
+
+func TestTrackConcurrently(t *testing.T) {
+	defer resetTracking()
+	Track(&TestResult{Folder: "concurrent"})
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			Track(&TestResult{Folder: "concurrent", Status: []TestStatus{{Test: "TestA", TestResult: "fail"}}})
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		FailingFolders()
+		FailingTests("concurrent")
+		LastResults()
+		ResetBaseline()
+	}
+	<-done
+	assert.Equal(t, []string{"concurrent"}, FailingFolders())
 }